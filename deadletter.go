@@ -0,0 +1,136 @@
+package queuesgo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// Extra metadata attributes added to the events sent to a dead letter queue
+const (
+	DeadLetterReason       = "dead_letter_reason"       // Why the event couldn't be handled
+	DeadLetterAttempts     = "dead_letter_attempts"     // Times the handler was called for the event
+	DeadLetterSubscription = "dead_letter_subscription" // Subscription where the event was received
+	DeadLetterError        = "dead_letter_error"        // Last error returned by the handler, if any
+)
+
+// Reasons of the events sent to a dead letter queue
+const (
+	ReasonNotAcknowledged = "not_acknowledged" // The handler didn't acknowledge the event without returning an error
+	ReasonHandlerError    = "handler_error"    // The handler didn't acknowledge the event returning an error
+	ReasonUnregistered    = "unregistered"     // There was no handler for the event, see DeadLetterUnknownEvents
+)
+
+/*
+Destination of the events that cannot be handled by a subscriber
+The events are republished through the publisher with the dead letter attributes on the extra metadata
+When the publisher is a TypedPublisher not accepting the payload of an event but accepting a map[string]interface{},
+the payload is sent as a map, so a publisher with a map type takes the events of every handler of a subscriber
+*/
+type DeadLetterQueue struct {
+	Publisher    Publisher
+	Subscription string
+}
+
+// genericPayload is the type of the payloads sent as a map
+var genericPayload = reflect.TypeOf(map[string]interface{}{})
+
+/*
+Returns an error if the publisher is a TypedPublisher not accepting the payloads of the given type, neither as a map
+The subscribers check the payload types of their handlers when they are registered
+*/
+func (d *DeadLetterQueue) CheckPayloadType(payloadType reflect.Type) error {
+	typed, ok := d.Publisher.(TypedPublisher)
+	if !ok || typed.AcceptsPayload(zeroPayload(payloadType)) || typed.AcceptsPayload(zeroPayload(genericPayload)) {
+		return nil
+	}
+	return fmt.Errorf("the dead letter publisher doesn't accept the payload type %s", payloadType)
+}
+
+// zeroPayload returns an empty payload of the type, allocating the value of the pointer types
+func zeroPayload(t reflect.Type) interface{} {
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem()).Interface()
+	}
+	return reflect.Zero(t).Interface()
+}
+
+type attemptsKey struct{}
+
+/*
+Republishes the event on the dead letter queue waiting for the queue provider confirmation
+The event received is not modified, its payload is sent as a map when the publisher only accepts those
+*/
+func (d *DeadLetterQueue) Send(ctx context.Context, event Event, reason string, attempts int, handlerErr error) error {
+	extra := make(map[string]string, len(event.Metadata.Extra)+4)
+	for key, value := range event.Metadata.Extra {
+		extra[key] = value
+	}
+	extra[DeadLetterReason] = reason
+	extra[DeadLetterAttempts] = strconv.Itoa(attempts)
+	extra[DeadLetterSubscription] = d.Subscription
+	if handlerErr != nil {
+		extra[DeadLetterError] = handlerErr.Error()
+	}
+	event.Metadata.Extra = extra
+	if typed, ok := d.Publisher.(TypedPublisher); ok && !typed.AcceptsPayload(event.Payload) &&
+		typed.AcceptsPayload(zeroPayload(genericPayload)) {
+		payload, err := toGenericPayload(event.Payload)
+		if err != nil {
+			return err
+		}
+		event.Payload = payload
+	}
+	_, err := d.Publisher.PublishSync(ctx, &event)
+	return err
+}
+
+// toGenericPayload returns the payload as the map written by encoding/json
+func toGenericPayload(payload interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var generic map[string]interface{}
+	err = json.Unmarshal(data, &generic)
+	return generic, err
+}
+
+/*
+Sends to the dead letter queue the events not acknowledged by the handler, acknowledging them afterwards
+It should wrap the Retry middleware so the event is sent once the retries are exhausted
+If the event cannot be republished it is not acknowledged, leaving the resend to the queue provider
+*/
+func (d *DeadLetterQueue) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event Event) (bool, error) {
+			attempts := 1
+			ack, err := next(context.WithValue(ctx, attemptsKey{}, &attempts), event)
+			// Events interrupted by the subscriber shutdown are left to the queue provider
+			if ack || ctx.Err() != nil {
+				return ack, err
+			}
+			reason := ReasonNotAcknowledged
+			if err != nil {
+				reason = ReasonHandlerError
+			}
+			dlqErr := d.Send(ctx, event, reason, attempts, err)
+			if dlqErr != nil {
+				if err != nil {
+					return false, fmt.Errorf("%s, dead letter failed: %s", err.Error(), dlqErr.Error())
+				}
+				return false, fmt.Errorf("dead letter failed: %s", dlqErr.Error())
+			}
+			return true, err
+		}
+	}
+}
+
+// recordAttempt stores the attempt for the DeadLetterQueue middleware wrapping the handler, if any
+func recordAttempt(ctx context.Context, attempt int) {
+	if attempts, ok := ctx.Value(attemptsKey{}).(*int); ok {
+		*attempts = attempt
+	}
+}
@@ -2,6 +2,7 @@ package queuesgo
 
 import (
 	"encoding/json"
+	"strconv"
 )
 
 type Event struct {
@@ -10,18 +11,78 @@ type Event struct {
 }
 
 type EventMetadata struct {
-	UserID        string `json:"user_id"`        // Id of the user triggering the event
-	CorrelationID string `json:"correlation_id"` // Unique ID of the event, generated as is triggered the first time
-	EventName     string `json:"event_name"`     // Event name (Shouldn't include origin or destination as is implicit on the topic/subscription and the extra origin field)
-	Origin        string `json:"origin"`         // Service originating the event
-	Timestamp     int64  `json:"timestamp"`      // Moment of the event generation (epoch millis)
-	ObjectID      string `json:"object_id"`      // ID of the object changing on the event
+	UserID        string            `json:"user_id"`            // Id of the user triggering the event
+	CorrelationID string            `json:"correlation_id"`     // Unique ID of the event, generated as is triggered the first time
+	EventName     string            `json:"event_name"`         // Event name (Shouldn't include origin or destination as is implicit on the topic/subscription and the extra origin field)
+	Origin        string            `json:"origin"`             // Service originating the event
+	Timestamp     int64             `json:"timestamp"`          // Moment of the event generation (epoch millis)
+	ObjectID      string            `json:"object_id"`          // ID of the object changing on the event
+	EventID       string            `json:"event_id,omitempty"` // Optional unique ID of the event, stable across resends
+	Extra         map[string]string `json:"extra,omitempty"`    // Additional attributes sent with the event (dead letter information...)
 }
 
 func (em *EventMetadata) IsZero() bool {
 	return em.CorrelationID == "" || em.EventName == "" || em.Timestamp == 0 || em.ObjectID == "" || em.Origin == ""
 }
 
+/*
+Returns the metadata as the attributes sent along the message by the queue providers
+The extra attributes are included but never override the fields of the metadata
+The timestamp is written as the decimal epoch millis and the user_id only when it is set. The earlier versions
+wrote the timestamp converting the number to a rune, which NewEventMetadata reads as a zero timestamp,
+and the Kafka publisher didn't send the user_id
+*/
+func (em *EventMetadata) Attributes() map[string]string {
+	attributes := make(map[string]string, len(em.Extra)+6)
+	for key, value := range em.Extra {
+		attributes[key] = value
+	}
+	attributes["correlation_id"] = em.CorrelationID
+	attributes["event_name"] = em.EventName
+	attributes["origin"] = em.Origin
+	attributes["object_id"] = em.ObjectID
+	attributes["timestamp"] = strconv.FormatInt(em.Timestamp, 10)
+	if em.UserID != "" {
+		attributes["user_id"] = em.UserID
+	} else {
+		delete(attributes, "user_id")
+	}
+	if em.EventID != "" {
+		attributes["event_id"] = em.EventID
+	} else {
+		delete(attributes, "event_id")
+	}
+	return attributes
+}
+
+/*
+Rebuilds the metadata from the attributes of a received message
+The attributes that don't belong to a field of the metadata are kept on Extra
+*/
+func NewEventMetadata(attributes map[string]string) EventMetadata {
+	intTimestamp, _ := strconv.ParseInt(attributes["timestamp"], 10, 64)
+	metadata := EventMetadata{
+		UserID:        attributes["user_id"],
+		CorrelationID: attributes["correlation_id"],
+		EventName:     attributes["event_name"],
+		Origin:        attributes["origin"],
+		Timestamp:     intTimestamp,
+		ObjectID:      attributes["object_id"],
+		EventID:       attributes["event_id"],
+	}
+	for key, value := range attributes {
+		switch key {
+		case "user_id", "correlation_id", "event_name", "origin", "timestamp", "object_id", "event_id":
+		default:
+			if metadata.Extra == nil {
+				metadata.Extra = make(map[string]string)
+			}
+			metadata.Extra[key] = value
+		}
+	}
+	return metadata
+}
+
 func (t Event) String() string {
 	payload, _ := json.Marshal(t)
 	return string(payload)
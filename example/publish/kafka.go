@@ -22,9 +22,9 @@ type Properties struct {
 }
 
 func main() {
-	pub := kafka.NewPublisher("localhost:9092", "http://localhost:8081", "events", Event{})
-	if pub == nil {
-		panic("cannot create publisher")
+	pub, err := kafka.NewPublisherWithOptions("localhost:9092", "http://localhost:8081", "events", Event{})
+	if err != nil {
+		panic(err)
 	}
 	var n int
 
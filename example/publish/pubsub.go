@@ -17,7 +17,10 @@ type Book struct {
 }
 
 func main() {
-	pub := pubsub.NewPublisher(os.Getenv("PROJECT"), "book", &Book{})
+	pub, err := pubsub.NewPublisherWithOptions(os.Getenv("PROJECT"), "book", &Book{})
+	if err != nil {
+		panic(err)
+	}
 
 	book := &Book{
 		ID:       "test-id",
@@ -15,11 +15,14 @@ type BookReplica struct {
 }
 
 func main() {
-	sub := pubsub.NewSubscriber(os.Getenv("PROJECT"), "books-replica", &BookReplica{}, true)
+	sub, err := pubsub.NewSubscriberWithOptions(os.Getenv("PROJECT"), "books-replica", &BookReplica{}, pubsub.WithLogMode(true))
+	if err != nil {
+		panic(err)
+	}
 	_ = sub.RegisterFunction("create", handleBookCreation)
 	_ = sub.RegisterFunction("inactive", handleBookInactivation)
 
-	err := sub.Subscribe(context.Background())
+	err = sub.Subscribe(context.Background())
 	if err != nil {
 		fmt.Println(err)
 		panic("something went really wrong")
@@ -0,0 +1,66 @@
+// Package idempotency skips the events already handled by a subscriber, as the queue providers deliver at least once
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	queuesgo "github.com/merlinapp/queues-go"
+	"strconv"
+	"time"
+)
+
+// Store keeps the keys of the events handled successfully
+type Store interface {
+	// Exists returns if the key was saved and hasn't expired
+	Exists(ctx context.Context, key string) (bool, error)
+	// Save keeps the key during the ttl
+	Save(ctx context.Context, key string, ttl time.Duration) error
+}
+
+/*
+Returns the identity of the event inside the namespace
+If the event has an EventID it is used, otherwise it is composed of CorrelationID, EventName, ObjectID and Timestamp
+The key is hashed so it has a fixed length
+*/
+func Key(namespace string, event queuesgo.Event) string {
+	var identity string
+	if event.Metadata.EventID != "" {
+		identity = "id|" + event.Metadata.EventID
+	} else {
+		identity = "event|" + event.Metadata.CorrelationID + "|" + event.Metadata.EventName + "|" +
+			event.Metadata.ObjectID + "|" + strconv.FormatInt(event.Metadata.Timestamp, 10)
+	}
+	// The length of the namespace keeps it from being confused with the identity
+	identity = strconv.Itoa(len(namespace)) + "|" + namespace + "|" + identity
+	hash := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(hash[:])
+}
+
+/*
+Skips the events whose key is on the store, acknowledging them without calling the handler
+The keys are scoped by the namespace, usually the subscription or the consumer group of the subscriber,
+so the subscribers sharing a store only skip the events they handled themselves
+The key is saved during the ttl once the handler acknowledges the event without error,
+so events delivered concurrently may still be handled more than once
+If the store fails before calling the handler the event is not acknowledged
+*/
+func Middleware(store Store, namespace string, ttl time.Duration) queuesgo.Middleware {
+	return func(next queuesgo.HandlerFunc) queuesgo.HandlerFunc {
+		return func(ctx context.Context, event queuesgo.Event) (bool, error) {
+			key := Key(namespace, event)
+			exists, err := store.Exists(ctx, key)
+			if err != nil {
+				return false, err
+			}
+			if exists {
+				return true, nil
+			}
+			ack, err := next(ctx, event)
+			if !ack || err != nil {
+				return ack, err
+			}
+			return ack, store.Save(ctx, key, ttl)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func newEvent() queuesgo.Event {
+	return queuesgo.Event{Metadata: queuesgo.EventMetadata{
+		CorrelationID: "correlation",
+		EventName:     "order_created",
+		Origin:        "test",
+		Timestamp:     1600000000000,
+		ObjectID:      "1",
+	}}
+}
+
+func TestKeyIsScopedByNamespace(t *testing.T) {
+	event := newEvent()
+
+	assert.Equal(t, Key("billing", event), Key("billing", event))
+	assert.NotEqual(t, Key("billing", event), Key("shipping", event))
+	assert.NotEqual(t, Key("", event), Key("billing", event))
+	withID := newEvent()
+	withID.Metadata.EventID = "event-1"
+	assert.NotEqual(t, Key("billing", event), Key("billing", withID))
+	assert.Len(t, Key("billing", event), 64)
+}
+
+// handle runs the event through the middleware, returning the times the handler was called
+func handle(t *testing.T, middleware queuesgo.Middleware, event queuesgo.Event, times int) int {
+	t.Helper()
+	calls := 0
+	handler := middleware(func(ctx context.Context, event queuesgo.Event) (bool, error) {
+		calls++
+		return true, nil
+	})
+	for i := 0; i < times; i++ {
+		ack, err := handler(context.Background(), event)
+		require.NoError(t, err)
+		assert.True(t, ack)
+	}
+	return calls
+}
+
+func TestMiddlewareSharedStore(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "idempotency.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(CreateTable("handled_events"))
+	require.NoError(t, err)
+	stores := map[string]Store{
+		"memory": NewMemoryStore(10),
+		"sql":    NewSQLStore(db, SQLite, "handled_events"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			event := newEvent()
+
+			assert.Equal(t, 1, handle(t, Middleware(store, "billing", time.Minute), event, 2))
+			assert.Equal(t, 1, handle(t, Middleware(store, "shipping", time.Minute), event, 2))
+			assert.Equal(t, 0, handle(t, Middleware(store, "billing", time.Minute), event, 1))
+		})
+	}
+}
+
+func TestSQLStoreExpiration(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "idempotency.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(CreateTable("handled_events"))
+	require.NoError(t, err)
+	store := NewSQLStore(db, SQLite, "handled_events")
+	ctx := context.Background()
+
+	require.NoError(t, store.Save(ctx, "expired", -time.Second))
+	require.NoError(t, store.Save(ctx, "kept", time.Minute))
+	exists, err := store.Exists(ctx, "expired")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = store.Exists(ctx, "kept")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	deleted, err := store.DeleteExpired(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+}
@@ -0,0 +1,73 @@
+package idempotency
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping the most recently saved keys in memory, it is not shared between processes
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type memoryEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a store keeping up to capacity keys, evicting the least recently used ones
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (s *MemoryStore) Exists(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(element.Value.(*memoryEntry).expiresAt) {
+		s.remove(element)
+		return false, nil
+	}
+	s.order.MoveToFront(element)
+	return true, nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt := time.Now().Add(ttl)
+	if element, ok := s.entries[key]; ok {
+		element.Value.(*memoryEntry).expiresAt = expiresAt
+		s.order.MoveToFront(element)
+		return nil
+	}
+	s.entries[key] = s.order.PushFront(&memoryEntry{key: key, expiresAt: expiresAt})
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Len returns the amount of keys kept, including the expired ones not evicted yet
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*memoryEntry).key)
+}
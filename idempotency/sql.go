@@ -0,0 +1,94 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Dialect adapts the statements of the store to a database engine
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	Postgres
+	MySQL
+)
+
+/*
+SQLStore is a Store keeping the keys on a database table, it can be shared by several subscribers
+as the Middleware scopes the keys by its namespace
+*/
+type SQLStore struct {
+	db      *sql.DB
+	dialect Dialect
+	table   string
+}
+
+/*
+Returns the statement creating the table of the store with the given name
+Columns:
+event_key: key of the event handled
+expires_at: moment the key expires (epoch millis)
+*/
+func CreateTable(table string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	event_key VARCHAR(64) NOT NULL PRIMARY KEY,
+	expires_at BIGINT NOT NULL
+)`, table)
+}
+
+// NewSQLStore creates a store over the given table, that must already exist, see CreateTable
+func NewSQLStore(db *sql.DB, dialect Dialect, table string) *SQLStore {
+	return &SQLStore{
+		db:      db,
+		dialect: dialect,
+		table:   table,
+	}
+}
+
+func (s *SQLStore) Exists(ctx context.Context, key string) (bool, error) {
+	var expiresAt int64
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT expires_at FROM %s WHERE event_key = %s", s.table, s.param(1)), key).
+		Scan(&expiresAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return expiresAt > millis(time.Now()), nil
+}
+
+func (s *SQLStore) Save(ctx context.Context, key string, ttl time.Duration) error {
+	var statement string
+	if s.dialect == MySQL {
+		statement = fmt.Sprintf("INSERT INTO %s (event_key, expires_at) VALUES (?, ?) ON DUPLICATE KEY UPDATE expires_at = VALUES(expires_at)", s.table)
+	} else {
+		statement = fmt.Sprintf("INSERT INTO %s (event_key, expires_at) VALUES (%s, %s) ON CONFLICT (event_key) DO UPDATE SET expires_at = excluded.expires_at",
+			s.table, s.param(1), s.param(2))
+	}
+	_, err := s.db.ExecContext(ctx, statement, key, millis(time.Now().Add(ttl)))
+	return err
+}
+
+// DeleteExpired removes the expired keys, returning the amount removed
+func (s *SQLStore) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE expires_at <= %s", s.table, s.param(1)), millis(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLStore) param(position int) string {
+	if s.dialect == Postgres {
+		return fmt.Sprintf("$%d", position)
+	}
+	return "?"
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,99 @@
+// Package inflight keeps track of the asynchronous publications of a publisher so it can be closed gracefully
+package inflight
+
+import (
+	"context"
+	"fmt"
+	queuesgo "github.com/merlinapp/queues-go"
+	"sync"
+)
+
+// Publications counts the outstanding publications of a publisher
+type Publications struct {
+	mu      sync.Mutex
+	pending int
+	closed  bool
+	idle    chan struct{} // closed once there are no pending publications after closing
+	errs    []error
+}
+
+/*
+Registers n new publications, Done must be called with the result of each one
+Returns ErrPublisherClosed if Close was already called
+*/
+func (p *Publications) Add(n int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return queuesgo.ErrPublisherClosed
+	}
+	p.pending += n
+	return nil
+}
+
+// Done ends a publication, the error is kept if the publications were closed
+func (p *Publications) Done(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending--
+	if p.closed && err != nil {
+		p.errs = append(p.errs, err)
+	}
+	if p.closed && p.pending == 0 {
+		close(p.idle)
+	}
+}
+
+/*
+Rejects the new publications, which return ErrPublisherClosed
+Returns ErrPublisherClosed if it was already closed
+*/
+func (p *Publications) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return queuesgo.ErrPublisherClosed
+	}
+	p.closed = true
+	p.idle = make(chan struct{})
+	if p.pending == 0 {
+		close(p.idle)
+	}
+	return nil
+}
+
+/*
+Waits after closing until the pending publications are done or the context is done
+Returns the errors of the publications that failed after closing and, if the context is done first,
+one error for the ones still pending
+*/
+func (p *Publications) Wait(ctx context.Context) queuesgo.PublicationErrors {
+	var timeout error
+	select {
+	case <-p.idle:
+	case <-ctx.Done():
+		timeout = ctx.Err()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	errs := queuesgo.PublicationErrors(append([]error(nil), p.errs...))
+	if timeout != nil && p.pending > 0 {
+		errs = append(errs, fmt.Errorf("%d publications not confirmed: %w", p.pending, timeout))
+	}
+	return errs
+}
+
+// Idle returns a channel closed once there are no pending publications after closing, nil before closing
+func (p *Publications) Idle() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idle
+}
+
+// Pending returns the amount of publications not done yet
+func (p *Publications) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending
+}
@@ -0,0 +1,232 @@
+// Package router holds the event routing shared by the subscriber implementations
+package router
+
+import (
+	"context"
+	"errors"
+	queuesgo "github.com/merlinapp/queues-go"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// Router dispatches the received events to the handler registered for its event name
+type Router struct {
+	elements    []routerElement
+	fallback    *routerElement
+	middlewares []queuesgo.Middleware
+	objectType  reflect.Type
+	config      Config
+}
+
+type routerElement struct {
+	event       string                // exact event name or glob pattern
+	pattern     bool                  // the event is a glob pattern
+	match       queuesgo.EventMatcher // used instead of the event name when set
+	payloadType reflect.Type          // nil to use the type of the subscriber
+	handlerFunc queuesgo.HandlerFunc
+}
+
+func (e *routerElement) matches(metadata queuesgo.EventMetadata) bool {
+	switch {
+	case e.match != nil:
+		return e.match(metadata)
+	case e.pattern:
+		matched, _ := path.Match(e.event, metadata.EventName)
+		return matched
+	default:
+		return e.event == metadata.EventName
+	}
+}
+
+// Config holds the subscriber options handled by the router
+type Config struct {
+	Logger             queuesgo.Logger
+	RetryPolicy        *queuesgo.RetryPolicy
+	DeadLetter         *queuesgo.DeadLetterQueue
+	UnknownEventPolicy queuesgo.UnknownEventPolicy
+}
+
+/*
+New creates a router for events with payloads of the objectType
+Returns an error if the unknown events must be dead lettered without a dead letter queue,
+or if the dead letter queue doesn't accept the objectType
+*/
+func New(objectType reflect.Type, config Config) (*Router, error) {
+	if config.UnknownEventPolicy == queuesgo.DeadLetterUnknownEvents && config.DeadLetter == nil {
+		return nil, errors.New("invalid unknown event policy, there is no dead letter queue")
+	}
+	if config.DeadLetter != nil {
+		if err := config.DeadLetter.CheckPayloadType(objectType); err != nil {
+			return nil, err
+		}
+	}
+	return &Router{
+		objectType: objectType,
+		config:     config,
+	}, nil
+}
+
+/*
+RegisterFunction registers the handler for the events with the given name
+The name can be a glob pattern as path.Match, like order.*, the exact names are looked up before the patterns
+*/
+func (r *Router) RegisterFunction(eventName string, handler queuesgo.HandlerFunc) error {
+	return r.register(eventName, nil, handler)
+}
+
+/*
+RegisterTypedFunction registers the handler for the events with the given name,
+decoding their payloads into the payloadType instead of the type of the subscriber
+Returns an error if the dead letter queue doesn't accept the payloadType
+*/
+func (r *Router) RegisterTypedFunction(eventName string, payloadType interface{}, handler queuesgo.HandlerFunc) error {
+	if !queuesgo.ValidateType(payloadType) {
+		return errors.New("invalid payload type")
+	}
+	if r.config.DeadLetter != nil {
+		if err := r.config.DeadLetter.CheckPayloadType(reflect.TypeOf(payloadType)); err != nil {
+			return err
+		}
+	}
+	return r.register(eventName, reflect.TypeOf(payloadType), handler)
+}
+
+func (r *Router) register(eventName string, payloadType reflect.Type, handler queuesgo.HandlerFunc) error {
+	if eventName == "" {
+		return errors.New("invalid event name")
+	}
+	pattern := strings.ContainsAny(eventName, `*?[\`)
+	if _, err := path.Match(eventName, ""); pattern && err != nil {
+		return errors.New("invalid event name pattern")
+	}
+	r.elements = append(r.elements, routerElement{event: eventName, pattern: pattern, payloadType: payloadType, handlerFunc: handler})
+	return nil
+}
+
+// RegisterMatchFunction registers the handler for the events matched by the function, checked after the patterns
+func (r *Router) RegisterMatchFunction(match queuesgo.EventMatcher, handler queuesgo.HandlerFunc) error {
+	if match == nil {
+		return errors.New("invalid matcher")
+	}
+	r.elements = append(r.elements, routerElement{match: match, handlerFunc: handler})
+	return nil
+}
+
+// RegisterFallback registers the handler for the events not matched by any other handler, replacing the previous one
+func (r *Router) RegisterFallback(handler queuesgo.HandlerFunc) error {
+	if handler == nil {
+		return errors.New("invalid handler")
+	}
+	r.fallback = &routerElement{handlerFunc: handler}
+	return nil
+}
+
+// Use adds middlewares applied around the registered handlers
+func (r *Router) Use(middlewares ...queuesgo.Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+/*
+route returns the element handling the event: the one registered with its exact name,
+or else the first pattern matching it, or else the first matcher matching it, or else the fallback
+Returns nil if there is none
+*/
+func (r *Router) route(metadata queuesgo.EventMetadata) *routerElement {
+	for _, kind := range []func(*routerElement) bool{
+		func(e *routerElement) bool { return !e.pattern && e.match == nil },
+		func(e *routerElement) bool { return e.pattern },
+		func(e *routerElement) bool { return e.match != nil },
+	} {
+		for i := range r.elements {
+			element := &r.elements[i]
+			if kind(element) && element.matches(metadata) {
+				return element
+			}
+		}
+	}
+	return r.fallback
+}
+
+/*
+NewPayload returns a pointer to a new value of the type registered for the event, ready to be unmarshalled
+The type of the subscriber is used for the events without a typed handler
+*/
+func (r *Router) NewPayload(metadata queuesgo.EventMetadata) interface{} {
+	payloadType := r.payloadType(metadata)
+	if payloadType.Kind() == reflect.Ptr {
+		return reflect.New(payloadType.Elem()).Interface()
+	}
+	return reflect.New(payloadType).Interface()
+}
+
+// payloadType returns the type of the payload of the event
+func (r *Router) payloadType(metadata queuesgo.EventMetadata) reflect.Type {
+	if element := r.route(metadata); element != nil && element.payloadType != nil {
+		return element.payloadType
+	}
+	return r.objectType
+}
+
+/*
+Calls the handler routed for the event wrapped by the middlewares,
+retrying it according to the retry policy and sending it to the dead letter queue if any
+Returns if the message should be acknowledged to the queue provider,
+events without a handler go through the middlewares and are handled according to the unknown event policy
+Events with a payload not matching the type of the handler are logged and not acknowledged
+*/
+func (r *Router) Manager(ctx context.Context, event queuesgo.Event) bool {
+	element := r.route(event.Metadata)
+	if element == nil {
+		return r.unknown(ctx, event)
+	}
+	if !queuesgo.ValidateRegisteredType(event.Payload, r.payloadType(event.Metadata)) {
+		r.config.Logger.Error("The received event cannot be used on the registered type", queuesgo.EventFields(event)...)
+		return false
+	}
+	handler := queuesgo.Chain(element.handlerFunc, r.middlewares...)
+	if r.config.RetryPolicy != nil {
+		handler = queuesgo.Retry(*r.config.RetryPolicy)(handler)
+	}
+	if r.config.DeadLetter != nil {
+		handler = r.config.DeadLetter.Middleware()(handler)
+	}
+	ack, err := handler(ctx, event)
+	// The acknowledgment of the message is handled by the handlerFunction regardless of the error
+	if err != nil {
+		r.config.Logger.Error("An error handling the event", append(queuesgo.EventFields(event), "ack", ack, "error", err)...)
+		return ack
+	}
+	r.config.Logger.Debug("Operation was called for event", append(queuesgo.EventFields(event), "ack", ack)...)
+	return ack
+}
+
+// unknown handles the events without a handler according to the unknown event policy
+func (r *Router) unknown(ctx context.Context, event queuesgo.Event) bool {
+	policy := r.config.UnknownEventPolicy
+	unregistered := func(ctx context.Context, event queuesgo.Event) (bool, error) {
+		return policy == queuesgo.AckUnknownEvents, queuesgo.ErrUnregisteredEvent
+	}
+	ack, err := queuesgo.Chain(unregistered, r.middlewares...)(ctx, event)
+	if !errors.Is(err, queuesgo.ErrUnregisteredEvent) {
+		// A middleware answered without calling the handler
+		if err != nil {
+			r.config.Logger.Error("An error handling the event", append(queuesgo.EventFields(event), "ack", ack, "error", err)...)
+		}
+		return ack
+	}
+	r.config.Logger.Warn("No function was registered for the event", append(queuesgo.EventFields(event), "policy", policy)...)
+	if policy != queuesgo.DeadLetterUnknownEvents {
+		return ack
+	}
+	if err = r.config.DeadLetter.Send(ctx, event, queuesgo.ReasonUnregistered, 1, err); err != nil {
+		r.config.Logger.Error("An error sending the event to the dead letter queue", append(queuesgo.EventFields(event), "error", err)...)
+		return false
+	}
+	return true
+}
+
+// Logger returns the logger of the subscriber
+func (r *Router) Logger() queuesgo.Logger {
+	return r.config.Logger
+}
@@ -0,0 +1,160 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	ID string `json:"id"`
+}
+
+// deadLetterPublisher keeps the events sent to the dead letter queue, failing with err
+type deadLetterPublisher struct {
+	events []*queuesgo.Event
+	err    error
+}
+
+func (p *deadLetterPublisher) PublishSync(ctx context.Context, event *queuesgo.Event) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	p.events = append(p.events, event)
+	return "sent", nil
+}
+
+func (p *deadLetterPublisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
+	return nil, errors.New("not expected")
+}
+
+func newRouter(t *testing.T, config Config) *Router {
+	t.Helper()
+	config.Logger = queuesgo.NopLogger()
+	r, err := New(reflect.TypeOf(order{}), config)
+	require.NoError(t, err)
+	return r
+}
+
+func newEvent(r *Router, eventName, origin string) queuesgo.Event {
+	metadata := queuesgo.EventMetadata{EventName: eventName, Origin: origin, ObjectID: "1"}
+	return queuesgo.Event{Payload: r.NewPayload(metadata), Metadata: metadata}
+}
+
+// handler acknowledges the events, recording its name as the handler called
+func handler(name string, called *string) queuesgo.HandlerFunc {
+	return func(ctx context.Context, event queuesgo.Event) (bool, error) {
+		*called = name
+		return true, nil
+	}
+}
+
+func TestRoutePrecedence(t *testing.T) {
+	var called string
+	r := newRouter(t, Config{})
+	require.NoError(t, r.RegisterMatchFunction(queuesgo.MatchOrigin("billing"), handler("matcher", &called)))
+	require.NoError(t, r.RegisterFunction("order.*", handler("glob", &called)))
+	require.NoError(t, r.RegisterFunction("order.created", handler("exact", &called)))
+	require.NoError(t, r.RegisterFallback(handler("fallback", &called)))
+
+	tests := []struct {
+		name      string
+		eventName string
+		origin    string
+		expected  string
+	}{
+		{name: "exact name before the patterns", eventName: "order.created", origin: "billing", expected: "exact"},
+		{name: "glob before the matcher", eventName: "order.paid", origin: "billing", expected: "glob"},
+		{name: "glob", eventName: "order.paid", origin: "shop", expected: "glob"},
+		{name: "matcher", eventName: "refund.created", origin: "billing", expected: "matcher"},
+		{name: "fallback", eventName: "refund.created", origin: "shop", expected: "fallback"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			called = ""
+			assert.True(t, r.Manager(context.Background(), newEvent(r, test.eventName, test.origin)))
+			assert.Equal(t, test.expected, called)
+		})
+	}
+}
+
+func TestRouteInRegistrationOrder(t *testing.T) {
+	var called string
+	r := newRouter(t, Config{})
+	require.NoError(t, r.RegisterFunction("order.*", handler("first glob", &called)))
+	require.NoError(t, r.RegisterFunction("order.?aid", handler("second glob", &called)))
+	require.NoError(t, r.RegisterMatchFunction(queuesgo.MatchOrigin("billing"), handler("first matcher", &called)))
+	require.NoError(t, r.RegisterMatchFunction(queuesgo.MatchOrigin("billing", "shop"), handler("second matcher", &called)))
+
+	assert.True(t, r.Manager(context.Background(), newEvent(r, "order.paid", "billing")))
+	assert.Equal(t, "first glob", called)
+	assert.True(t, r.Manager(context.Background(), newEvent(r, "refund.created", "billing")))
+	assert.Equal(t, "first matcher", called)
+}
+
+func TestRegisterInvalidNames(t *testing.T) {
+	r := newRouter(t, Config{})
+	assert.EqualError(t, r.RegisterFunction("", nil), "invalid event name")
+	assert.EqualError(t, r.RegisterFunction("order.[", nil), "invalid event name pattern")
+	assert.EqualError(t, r.RegisterMatchFunction(nil, nil), "invalid matcher")
+	assert.EqualError(t, r.RegisterFallback(nil), "invalid handler")
+}
+
+func TestUnknownEventPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        queuesgo.UnknownEventPolicy
+		deadLetterErr error
+		ack           bool
+		deadLettered  bool
+	}{
+		{name: "ack", policy: queuesgo.AckUnknownEvents, ack: true},
+		{name: "nack", policy: queuesgo.NackUnknownEvents, ack: false},
+		{name: "dead letter", policy: queuesgo.DeadLetterUnknownEvents, ack: true, deadLettered: true},
+		{name: "dead letter failing", policy: queuesgo.DeadLetterUnknownEvents, deadLetterErr: errors.New("unavailable"), ack: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			publisher := &deadLetterPublisher{err: test.deadLetterErr}
+			r := newRouter(t, Config{
+				UnknownEventPolicy: test.policy,
+				DeadLetter:         &queuesgo.DeadLetterQueue{Publisher: publisher, Subscription: "orders"},
+			})
+			var called string
+			require.NoError(t, r.RegisterFunction("order.created", handler("exact", &called)))
+
+			assert.Equal(t, test.ack, r.Manager(context.Background(), newEvent(r, "refund.created", "shop")))
+			assert.Empty(t, called)
+			if !test.deadLettered {
+				assert.Empty(t, publisher.events)
+				return
+			}
+			require.Len(t, publisher.events, 1)
+			extra := publisher.events[0].Metadata.Extra
+			assert.Equal(t, queuesgo.ReasonUnregistered, extra[queuesgo.DeadLetterReason])
+			assert.Equal(t, "orders", extra[queuesgo.DeadLetterSubscription])
+			assert.Equal(t, queuesgo.ErrUnregisteredEvent.Error(), extra[queuesgo.DeadLetterError])
+		})
+	}
+}
+
+func TestUnknownEventAnsweredByMiddleware(t *testing.T) {
+	r := newRouter(t, Config{UnknownEventPolicy: queuesgo.NackUnknownEvents})
+	r.Use(func(next queuesgo.HandlerFunc) queuesgo.HandlerFunc {
+		return func(ctx context.Context, event queuesgo.Event) (bool, error) {
+			return true, nil
+		}
+	})
+
+	assert.True(t, r.Manager(context.Background(), newEvent(r, "refund.created", "shop")))
+}
+
+func TestDeadLetterUnknownEventsRequiresADeadLetterQueue(t *testing.T) {
+	_, err := New(reflect.TypeOf(order{}), Config{UnknownEventPolicy: queuesgo.DeadLetterUnknownEvents})
+	assert.EqualError(t, err, "invalid unknown event policy, there is no dead letter queue")
+}
@@ -2,8 +2,11 @@ package kafka
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"reflect"
 	"sort"
+	"strings"
 )
 
 type Schema struct {
@@ -13,8 +16,9 @@ type Schema struct {
 }
 
 type Field struct {
-	Name string      `json:"name"`
-	Type interface{} `json:"type"`
+	Name    string      `json:"name"`
+	Type    interface{} `json:"type"`
+	Default interface{} `json:"default,omitempty"`
 }
 
 type ListField struct {
@@ -27,14 +31,61 @@ type MapField struct {
 	Values interface{} `json:"values"`
 }
 
-func createSchema(name string, fieldsMap map[string]interface{}) Schema {
-	fields := make([]Field, 0, len(fieldsMap))
-	for key, val := range fieldsMap {
-		fields = append(fields, createField(key, val))
+type EnumField struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Symbols []string `json:"symbols"`
+}
+
+// LogicalField is a primitive type annotated with a logical type, as timestamp-millis or date
+type LogicalField struct {
+	Type        string `json:"type"`
+	LogicalType string `json:"logicalType"`
+}
+
+// nullDefault is marshalled as the null default value of the nullable fields
+type nullDefault struct{}
+
+func (nullDefault) MarshalJSON() ([]byte, error) {
+	return []byte("null"), nil
+}
+
+/*
+Creates the Avro record schema from the map[string]interface{} with the names and types reflection, see queuesgo.GetFields
+The records and enums are defined the first time they appear, the next fields reference them by name
+The times are written as timestamp-millis, or date, which only hold the years 1678 to 2262, the zero time.Time is written
+as the earliest time of the type and read back as the zero time
+Returns an error for the types without an Avro type, the uint and uint64 fields don't fit a long
+*/
+func createSchema(name string, fieldsMap map[string]interface{}) (Schema, error) {
+	b := &schemaBuilder{defined: map[string]bool{name: true}}
+	schema := b.createRecord(name, fieldsMap)
+	return schema, b.err
+}
+
+type schemaBuilder struct {
+	defined map[string]bool // names of the records and enums already defined on the schema
+	path    []string        // names of the fields being created, from the outermost record
+	err     error           // first field without an Avro type
+}
+
+func (b *schemaBuilder) fail(reason string) {
+	if b.err == nil {
+		b.err = fmt.Errorf("field %s: %s", strings.Join(b.path, "."), reason)
+	}
+}
+
+func (b *schemaBuilder) createRecord(name string, fieldsMap map[string]interface{}) Schema {
+	keys := make([]string, 0, len(fieldsMap))
+	for key := range fieldsMap {
+		keys = append(keys, key)
+	}
+	// The fields are created in order, so the named types are defined on the first field using them
+	sort.Strings(keys)
+	fields := make([]Field, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, b.createField(key, fieldsMap[key]))
 	}
-	sort.Slice(fields, func(i, j int) bool {
-		return fields[i].Name < fields[j].Name
-	})
 	return Schema{
 		Type:   "record",
 		Name:   name,
@@ -42,76 +93,102 @@ func createSchema(name string, fieldsMap map[string]interface{}) Schema {
 	}
 }
 
-func createField(key string, val interface{}) Field {
-	var value interface{}
-	if reflect.TypeOf(val).Kind() == reflect.Slice {
-		value = createComplexField(val)
-	} else {
-		switch val {
-		case "int", "int8", "int32":
-			value = "int"
-		case "int64":
-			value = "long"
-		case "bool":
-			value = "boolean"
-		default:
-			value = val
-		}
-
-	}
+func (b *schemaBuilder) createField(key string, val interface{}) Field {
+	b.path = append(b.path, key)
+	defer func() {
+		b.path = b.path[:len(b.path)-1]
+	}()
 	f := Field{
 		Name: key,
-		Type: value,
+		Type: b.createType(val),
+	}
+	// The default of a union must match its first type, so the nullable fields are ["null", T]
+	if isNullable(val) {
+		f.Default = nullDefault{}
 	}
 	return f
 }
 
+func isNullable(val interface{}) bool {
+	v, ok := val.([]interface{})
+	return ok && v[0] == "nullable"
+}
+
+// createType returns the Avro type of a primitive type name or a complex type of the fields map
+func (b *schemaBuilder) createType(val interface{}) interface{} {
+	if reflect.TypeOf(val).Kind() == reflect.Slice {
+		return b.createComplexField(val)
+	}
+	switch val {
+	case "int", "int8", "int16", "int32", "uint8", "uint16":
+		return "int"
+	case "int64", "uint32":
+		return "long"
+	case "uint", "uint64":
+		b.fail(fmt.Sprintf("%s values above the maximum long cannot be written, use the json string option", val))
+		return "long"
+	case "float32":
+		return "float"
+	case "float64":
+		return "double"
+	case "bool":
+		return "boolean"
+	case "timestamp":
+		return LogicalField{Type: "long", LogicalType: "timestamp-millis"}
+	case "date":
+		return LogicalField{Type: "int", LogicalType: "date"}
+	default:
+		return val
+	}
+}
+
 /*
-Creates an Avro complex field (record, map, array) using the map[string]interface{} with the names and types reflection
-Enum is currently not supported here
+Creates an Avro complex field (record, map, array, union, enum) using the map[string]interface{} with the names and types reflection
 If the type is an array returns a ListField with items as primitive type or a complex embedded type
 If the type is map returns a MapField with values as primitive type or a complex embedded type
+If the type is nullable returns an union of null and the type
+If the type is enum returns an EnumField with the symbols
 If the type is any structure, returns a record type, represented on the schema
 */
-func createComplexField(val interface{}) interface{} {
+func (b *schemaBuilder) createComplexField(val interface{}) interface{} {
 	v := val.([]interface{})
 	t := v[0].(string)
-	tt, ok := v[1].(string)
 	switch t {
 	case "array":
-		return creatListField(ok, t, v[1])
-	case "map":
-		var values interface{}
-		if ok {
-			values = tt
-		} else {
-			values = createComplexField(v[1])
+		return ListField{
+			Type:  t,
+			Items: b.createType(v[1]),
 		}
+	case "map":
 		return MapField{
 			Type:   t,
-			Values: values,
+			Values: b.createType(v[1]),
+		}
+	case "nullable":
+		valueType := b.createType(v[1])
+		// A pointer to a pointer is a single nullable union, as encoding/json writes null for both nil pointers
+		if union, ok := valueType.([]interface{}); ok {
+			return union
+		}
+		return []interface{}{"null", valueType}
+	case "enum":
+		name := v[1].(string)
+		if b.defined[name] {
+			return name
+		}
+		b.defined[name] = true
+		return EnumField{
+			Type:    t,
+			Name:    name,
+			Symbols: v[2].([]string),
 		}
 	//Structures
 	default:
-		return createSchema(t, v[1].(map[string]interface{}))
-	}
-}
-
-func creatListField(ok bool, t string, value interface{}) ListField {
-	var items interface{}
-	if ok {
-		items = value.(string)
-
-	} else {
-		if val, ok := value.([]interface{}); ok {
-			items = createComplexField(val)
-		} else {
-			items = createSchema(t, value.(map[string]interface{}))
+		if b.defined[t] {
+			return t
 		}
-	}
-	return ListField{
-		Type:  t,
-		Items: items,
+		b.defined[t] = true
+		return b.createRecord(t, v[1].(map[string]interface{}))
 	}
 }
 
@@ -141,3 +218,17 @@ func (a *AvroEncoder) Encode() ([]byte, error) {
 func (a *AvroEncoder) Length() int {
 	return 5 + len(a.Content)
 }
+
+// Decode reads a message in the Confluent wire format, filling the schema ID and the Avro serialized content.
+// It returns an error if the message is too short or doesn't start with the magic byte.
+func (a *AvroEncoder) Decode(message []byte) error {
+	if len(message) < 5 {
+		return errors.New("message too short for the wire format")
+	}
+	if message[0] != byte(0) {
+		return errors.New("unknown magic byte")
+	}
+	a.SchemaID = int(binary.BigEndian.Uint32(message[1:5]))
+	a.Content = message[5:]
+	return nil
+}
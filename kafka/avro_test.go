@@ -0,0 +1,329 @@
+package kafka
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type listNode struct {
+	Name string    `json:"name"`
+	Next *listNode `json:"next"`
+}
+
+type department struct {
+	Name      string     `json:"name"`
+	Employees []employee `json:"employees"`
+}
+
+type employee struct {
+	Name       string      `json:"name"`
+	Department *department `json:"department"`
+}
+
+// roundTrip encodes the value with the schema generated for its type and decodes it back into a new value of the type
+func roundTrip(t *testing.T, value interface{}) interface{} {
+	t.Helper()
+	schema, err := createSchema(queuesgo.GetName(value), queuesgo.GetFields(value))
+	require.NoError(t, err)
+	schemaBytes, err := json.Marshal(schema)
+	require.NoError(t, err)
+	codec, err := goavro.NewCodec(string(schemaBytes))
+	require.NoError(t, err, string(schemaBytes))
+	parsed, err := parseAvroSchema(string(schemaBytes))
+	require.NoError(t, err)
+
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	native, err := parsed.toNative(data)
+	require.NoError(t, err)
+	binary, err := codec.BinaryFromNative(nil, native)
+	require.NoError(t, err)
+	decoded, _, err := codec.NativeFromBinary(binary)
+	require.NoError(t, err)
+	jsonValue, err := parsed.fromNative(decoded)
+	require.NoError(t, err)
+	jsonData, err := json.Marshal(jsonValue)
+	require.NoError(t, err)
+
+	result := reflect.New(reflect.TypeOf(value))
+	require.NoError(t, json.Unmarshal(jsonData, result.Interface()), string(jsonData))
+	return result.Elem().Interface()
+}
+
+func TestRecursiveTypesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{
+			name:  "self referencing pointer",
+			value: listNode{Name: "first", Next: &listNode{Name: "second", Next: &listNode{Name: "third"}}},
+		},
+		{
+			name:  "self referencing pointer ending in nil",
+			value: listNode{Name: "alone"},
+		},
+		{
+			name: "mutually recursive",
+			value: department{Name: "sales", Employees: []employee{
+				{Name: "ana", Department: &department{Name: "support", Employees: []employee{{Name: "bob"}}}},
+				{Name: "carl"},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.value, roundTrip(t, test.value))
+		})
+	}
+}
+
+type address struct {
+	Street string `json:"street"`
+	City   string `json:"city"`
+}
+
+type audit struct {
+	CreatedBy string `json:"created_by"`
+	Version   int    `json:"version"`
+}
+
+// Owner is exported as encoding/json cannot set embedded pointers to unexported types
+type Owner struct {
+	Name string `json:"name"`
+}
+
+type withEmbedded struct {
+	audit
+	*Owner
+	ID      string `json:"id"`
+	Version int    `json:"version"` // shadows the version of the embedded audit
+}
+
+type withTags struct {
+	Name     string  `json:"name"`
+	Nickname string  `json:"nickname,omitempty"`
+	Secret   string  `json:"-"`
+	Count    int64   `json:"count,string"`
+	Ratio    float64 `json:"ratio,string"`
+	Active   bool    `json:"active,string"`
+	Tags     []int   `json:"tags,omitempty"`
+	hidden   string
+}
+
+type withPointers struct {
+	Name    *string  `json:"name"`
+	Count   *int     `json:"count"`
+	Address *address `json:"address"`
+	Scores  *[]int   `json:"scores"`
+}
+
+type withDoublePointers struct {
+	Name  **string  `json:"name"`
+	Owner **address `json:"owner"`
+}
+
+type withTimes struct {
+	At       time.Time  `json:"at"`
+	Birthday time.Time  `json:"birthday" avro:"date"`
+	Deleted  *time.Time `json:"deleted"`
+}
+
+type color string
+
+type withEnums struct {
+	Color    color   `json:"color" avro:"enum=RED,GREEN,BLUE"`
+	Previous *color  `json:"previous" avro:"enum=RED,GREEN,BLUE"`
+	Status   string  `json:"status" avro:"enum=ACTIVE,INACTIVE"`
+	Colors   []color `json:"colors"`
+}
+
+type withBytes struct {
+	Data  []byte  `json:"data"`
+	Empty []byte  `json:"empty"`
+	Maybe *[]byte `json:"maybe"`
+}
+
+type withMaps struct {
+	Counts    map[string]int     `json:"counts"`
+	Addresses map[string]address `json:"addresses"`
+	Nested    map[string][]int   `json:"nested"`
+	Missing   map[string]string  `json:"missing"`
+}
+
+type withRecordSlices struct {
+	Addresses []address  `json:"addresses"`
+	Pointers  []*address `json:"pointers"`
+}
+
+type withNestedRecords struct {
+	Home     address  `json:"home"`
+	Work     address  `json:"work"`
+	Previous *address `json:"previous"`
+	Owner    struct {
+		Name    string  `json:"name"`
+		Address address `json:"address"`
+	} `json:"owner"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	name := "ana"
+	count := 3
+	scores := []int{1, 2}
+	deleted := time.Date(2020, 5, 6, 7, 8, 9, 123000000, time.UTC)
+	red := color("RED")
+	maybe := []byte{9, 8}
+	var nested withNestedRecords
+	nested.Home = address{Street: "main", City: "bogota"}
+	nested.Work = address{Street: "second", City: "lima"}
+	nested.Owner.Name = "bob"
+	nested.Owner.Address = address{City: "quito"}
+
+	namePointer := &name
+	var nilName *string
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{} // the value itself when nil
+	}{
+		{
+			name:  "pointers to pointers",
+			value: withDoublePointers{Name: &namePointer},
+		},
+		{
+			name:  "pointer to a nil pointer",
+			value: withDoublePointers{Name: &nilName},
+			// encoding/json writes null for both nil pointers
+			expected: withDoublePointers{},
+		},
+		{
+			name:  "embedded structs",
+			value: withEmbedded{audit: audit{CreatedBy: "ana", Version: 1}, Owner: &Owner{Name: "bob"}, ID: "1", Version: 2},
+			// the shadowed version of audit is not written by encoding/json
+			expected: withEmbedded{audit: audit{CreatedBy: "ana"}, Owner: &Owner{Name: "bob"}, ID: "1", Version: 2},
+		},
+		{
+			name:  "nil embedded pointer",
+			value: withEmbedded{ID: "1"},
+			// its promoted fields are read as nulls, for which encoding/json allocates the embedded pointer
+			expected: withEmbedded{ID: "1", Owner: &Owner{}},
+		},
+		{
+			name:     "json tags",
+			value:    withTags{Name: "a", Nickname: "b", Secret: "c", Count: 4, Ratio: 0.5, Active: true, Tags: []int{1}, hidden: "e"},
+			expected: withTags{Name: "a", Nickname: "b", Count: 4, Ratio: 0.5, Active: true, Tags: []int{1}},
+		},
+		{
+			name:  "omitted empty values",
+			value: withTags{Name: "a"},
+		},
+		{
+			name:  "pointers",
+			value: withPointers{Name: &name, Count: &count, Address: &address{City: "bogota"}, Scores: &scores},
+		},
+		{
+			name:  "nil pointers",
+			value: withPointers{},
+		},
+		{
+			name: "time and date",
+			value: withTimes{
+				At:       time.Date(2021, 1, 2, 3, 4, 5, 6000000, time.UTC),
+				Birthday: time.Date(1990, 12, 31, 0, 0, 0, 0, time.UTC),
+				Deleted:  &deleted,
+			},
+		},
+		{
+			name:  "zero time and date",
+			value: withTimes{},
+		},
+		{
+			name:  "enums",
+			value: withEnums{Color: "GREEN", Previous: &red, Status: "ACTIVE", Colors: []color{"blue"}},
+		},
+		{
+			name:  "bytes",
+			value: withBytes{Data: []byte("hello"), Empty: []byte{}, Maybe: &maybe},
+		},
+		{
+			name: "maps",
+			value: withMaps{
+				Counts:    map[string]int{"a": 1, "b": 2},
+				Addresses: map[string]address{"home": {Street: "main"}},
+				Nested:    map[string][]int{"x": {1, 2}},
+				Missing:   map[string]string{},
+			},
+		},
+		{
+			name:  "slices of records",
+			value: withRecordSlices{Addresses: []address{{City: "a"}, {City: "b"}}, Pointers: []*address{{City: "c"}, nil}},
+		},
+		{
+			name:  "nested named records",
+			value: nested,
+		},
+		{
+			name:  "recursive",
+			value: listNode{Name: "first", Next: &listNode{Name: "second"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := test.expected
+			if expected == nil {
+				expected = test.value
+			}
+			assert.Equal(t, expected, roundTrip(t, test.value))
+		})
+	}
+}
+
+func TestCreateSchemaUnsignedLongs(t *testing.T) {
+	type inner struct {
+		Count uint `json:"count"`
+	}
+	tests := []struct {
+		name  string
+		value interface{}
+		err   string
+	}{
+		{
+			name: "uint64",
+			value: struct {
+				Total uint64 `json:"total"`
+			}{},
+			err: "field total: uint64 values above the maximum long cannot be written, use the json string option",
+		},
+		{
+			name: "nested uint",
+			value: struct {
+				Inner []inner `json:"inner"`
+			}{},
+			err: "field inner.count: uint values above the maximum long cannot be written, use the json string option",
+		},
+		{
+			name: "uint64 with the string option",
+			value: struct {
+				Total uint64 `json:"total,string"`
+				Small uint32 `json:"small"`
+			}{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := createSchema("record", queuesgo.GetFields(test.value))
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
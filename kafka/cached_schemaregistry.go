@@ -5,6 +5,7 @@
 package kafka
 
 import (
+	"context"
 	"github.com/linkedin/goavro/v2"
 	"sync"
 )
@@ -14,7 +15,7 @@ type CachedSchemaRegistryClient struct {
 	SchemaRegistryClient *SchemaRegistryClient
 	schemaCache          map[int]*goavro.Codec
 	schemaCacheLock      sync.RWMutex
-	schemaIdCache        map[string]int
+	schemaIdCache        map[string]int // by subject and schema
 	schemaIdCacheLock    sync.RWMutex
 }
 
@@ -28,15 +29,24 @@ func NewCachedSchemaRegistryClientWithRetries(connect []string, retries int) *Ca
 	return &CachedSchemaRegistryClient{SchemaRegistryClient: SchemaRegistryClient, schemaCache: make(map[int]*goavro.Codec), schemaIdCache: make(map[string]int)}
 }
 
+// NewCachedSchemaRegistryClientWithOptions creates a cached client with the given authentication, TLS, timeout and retries
+func NewCachedSchemaRegistryClientWithOptions(connect []string, opts ...RegistryOption) (*CachedSchemaRegistryClient, error) {
+	SchemaRegistryClient, err := NewSchemaRegistryClientWithOptions(connect, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &CachedSchemaRegistryClient{SchemaRegistryClient: SchemaRegistryClient, schemaCache: make(map[int]*goavro.Codec), schemaIdCache: make(map[string]int)}, nil
+}
+
 // GetSchema will return and cache the codec with the given id
-func (client *CachedSchemaRegistryClient) GetSchema(id int) (*goavro.Codec, error) {
+func (client *CachedSchemaRegistryClient) GetSchema(ctx context.Context, id int) (*goavro.Codec, error) {
 	client.schemaCacheLock.RLock()
 	cachedResult := client.schemaCache[id]
 	client.schemaCacheLock.RUnlock()
 	if nil != cachedResult {
 		return cachedResult, nil
 	}
-	codec, err := client.SchemaRegistryClient.GetSchema(id)
+	codec, err := client.SchemaRegistryClient.GetSchema(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -47,35 +57,35 @@ func (client *CachedSchemaRegistryClient) GetSchema(id int) (*goavro.Codec, erro
 }
 
 // GetSubjects returns a list of subjects
-func (client *CachedSchemaRegistryClient) GetSubjects() ([]string, error) {
-	return client.SchemaRegistryClient.GetSubjects()
+func (client *CachedSchemaRegistryClient) GetSubjects(ctx context.Context) ([]string, error) {
+	return client.SchemaRegistryClient.GetSubjects(ctx)
 }
 
 // GetVersions returns a list of all versions of a subject
-func (client *CachedSchemaRegistryClient) GetVersions(subject string) ([]int, error) {
-	return client.SchemaRegistryClient.GetVersions(subject)
+func (client *CachedSchemaRegistryClient) GetVersions(ctx context.Context, subject string) ([]int, error) {
+	return client.SchemaRegistryClient.GetVersions(ctx, subject)
 }
 
 // GetSchemaByVersion returns the codec for a specific version of a subject
-func (client *CachedSchemaRegistryClient) GetSchemaByVersion(subject string, version int) (*goavro.Codec, error) {
-	return client.SchemaRegistryClient.GetSchemaByVersion(subject, version)
+func (client *CachedSchemaRegistryClient) GetSchemaByVersion(ctx context.Context, subject string, version int) (*goavro.Codec, error) {
+	return client.SchemaRegistryClient.GetSchemaByVersion(ctx, subject, version)
 }
 
 // GetLatestSchema returns the highest version schema for a subject
-func (client *CachedSchemaRegistryClient) GetLatestSchema(subject string) (*goavro.Codec, error) {
-	return client.SchemaRegistryClient.GetLatestSchema(subject)
+func (client *CachedSchemaRegistryClient) GetLatestSchema(ctx context.Context, subject string) (*goavro.Codec, error) {
+	return client.SchemaRegistryClient.GetLatestSchema(ctx, subject)
 }
 
-// CreateSubject will return and cache the id with the given codec
-func (client *CachedSchemaRegistryClient) CreateSubject(subject string, codec *goavro.Codec) (int, error) {
-	schemaJson := codec.Schema()
+// CreateSubject will return and cache the id with the given codec, the schema is registered once per subject
+func (client *CachedSchemaRegistryClient) CreateSubject(ctx context.Context, subject string, codec *goavro.Codec) (int, error) {
+	schemaJson := subject + ":" + codec.Schema()
 	client.schemaIdCacheLock.RLock()
 	cachedResult, found := client.schemaIdCache[schemaJson]
 	client.schemaIdCacheLock.RUnlock()
 	if found {
 		return cachedResult, nil
 	}
-	id, err := client.SchemaRegistryClient.CreateSubject(subject, codec)
+	id, err := client.SchemaRegistryClient.CreateSubject(ctx, subject, codec)
 	if err != nil {
 		return 0, err
 	}
@@ -86,16 +96,41 @@ func (client *CachedSchemaRegistryClient) CreateSubject(subject string, codec *g
 }
 
 // IsSchemaRegistered checks if a specific codec is already registered to a subject
-func (client *CachedSchemaRegistryClient) IsSchemaRegistered(subject string, codec *goavro.Codec) (int, error) {
-	return client.SchemaRegistryClient.IsSchemaRegistered(subject, codec)
+func (client *CachedSchemaRegistryClient) IsSchemaRegistered(ctx context.Context, subject string, codec *goavro.Codec) (int, error) {
+	return client.SchemaRegistryClient.IsSchemaRegistered(ctx, subject, codec)
+}
+
+// TestCompatibility tests the codec against a version of the subject, the result is not cached
+func (client *CachedSchemaRegistryClient) TestCompatibility(ctx context.Context, subject string, codec *goavro.Codec, version string) (*CompatibilityResult, error) {
+	return client.SchemaRegistryClient.TestCompatibility(ctx, subject, codec, version)
+}
+
+// GetGlobalCompatibilityLevel returns the compatibility level of the subjects without their own level
+func (client *CachedSchemaRegistryClient) GetGlobalCompatibilityLevel(ctx context.Context) (CompatibilityLevel, error) {
+	return client.SchemaRegistryClient.GetGlobalCompatibilityLevel(ctx)
+}
+
+// SetGlobalCompatibilityLevel updates the compatibility level of the subjects without their own level
+func (client *CachedSchemaRegistryClient) SetGlobalCompatibilityLevel(ctx context.Context, level CompatibilityLevel) error {
+	return client.SchemaRegistryClient.SetGlobalCompatibilityLevel(ctx, level)
+}
+
+// GetCompatibilityLevel returns the compatibility level of the subject
+func (client *CachedSchemaRegistryClient) GetCompatibilityLevel(ctx context.Context, subject string) (CompatibilityLevel, error) {
+	return client.SchemaRegistryClient.GetCompatibilityLevel(ctx, subject)
+}
+
+// SetCompatibilityLevel updates the compatibility level of the subject
+func (client *CachedSchemaRegistryClient) SetCompatibilityLevel(ctx context.Context, subject string, level CompatibilityLevel) error {
+	return client.SchemaRegistryClient.SetCompatibilityLevel(ctx, subject, level)
 }
 
 // DeleteSubject deletes the subject, should only be used in development
-func (client *CachedSchemaRegistryClient) DeleteSubject(subject string) error {
-	return client.SchemaRegistryClient.DeleteSubject(subject)
+func (client *CachedSchemaRegistryClient) DeleteSubject(ctx context.Context, subject string) error {
+	return client.SchemaRegistryClient.DeleteSubject(ctx, subject)
 }
 
 // DeleteVersion deletes the a specific version of a subject, should only be used in development.
-func (client *CachedSchemaRegistryClient) DeleteVersion(subject string, version int) error {
-	return client.SchemaRegistryClient.DeleteVersion(subject, version)
+func (client *CachedSchemaRegistryClient) DeleteVersion(ctx context.Context, subject string, version int) error {
+	return client.SchemaRegistryClient.DeleteVersion(ctx, subject, version)
 }
@@ -0,0 +1,446 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"math"
+	"strings"
+	"time"
+)
+
+/*
+Avro schema used to convert the payloads between their JSON form and the native form of goavro
+json.Marshal doesn't wrap the values of the unions with their type nor writes the times as numbers,
+as the Avro textual form expects, so the payloads are converted following the schema instead
+*/
+type avroSchema struct {
+	schema interface{}
+	names  map[string]namedType // records, enums and fixed types by full name
+}
+
+type namedType struct {
+	schema    map[string]interface{}
+	namespace string
+}
+
+// logicalTypes are the logical types known by goavro, the union members with them are named type.logicalType
+var logicalTypes = map[string]bool{
+	"long.timestamp-millis": true,
+	"long.timestamp-micros": true,
+	"int.time-millis":       true,
+	"long.time-micros":      true,
+	"int.date":              true,
+	"bytes.decimal":         true,
+	"fixed.decimal":         true,
+}
+
+// Range of the times supported by the logical types
+var (
+	minTime = time.Unix(0, math.MinInt64)
+	maxTime = time.Unix(0, math.MaxInt64)
+)
+
+// Units of the logical types of the times
+var timeUnits = map[string]int64{
+	"timestamp-millis": int64(time.Millisecond),
+	"timestamp-micros": int64(time.Microsecond),
+	"date":             int64(24 * time.Hour),
+}
+
+/*
+zeroTime returns the earliest time of the logical type, written instead of the zero time.Time which is out of its range,
+and read back as the zero time
+*/
+func zeroTime(logicalType string) time.Time {
+	unit := timeUnits[logicalType]
+	return time.Unix(0, math.MinInt64/unit*unit).UTC()
+}
+
+func parseAvroSchema(schema string) (*avroSchema, error) {
+	s := &avroSchema{names: make(map[string]namedType)}
+	if err := json.Unmarshal([]byte(schema), &s.schema); err != nil {
+		return nil, err
+	}
+	s.collectNames(s.schema, "")
+	return s, nil
+}
+
+// collectNames registers the named types defined on the schema, so the references to them can be resolved
+func (s *avroSchema) collectNames(schema interface{}, namespace string) {
+	switch t := schema.(type) {
+	case []interface{}:
+		for _, member := range t {
+			s.collectNames(member, namespace)
+		}
+	case map[string]interface{}:
+		switch t["type"] {
+		case "record", "enum", "fixed":
+			name, namespace := fullName(t, namespace)
+			s.names[name] = namedType{schema: t, namespace: namespace}
+			if fields, ok := t["fields"].([]interface{}); ok {
+				for _, field := range fields {
+					if f, ok := field.(map[string]interface{}); ok {
+						s.collectNames(f["type"], namespace)
+					}
+				}
+			}
+		case "array":
+			s.collectNames(t["items"], namespace)
+		case "map":
+			s.collectNames(t["values"], namespace)
+		default:
+			s.collectNames(t["type"], namespace)
+		}
+	}
+}
+
+// fullName returns the full name of a named type and the namespace of its children
+func fullName(schema map[string]interface{}, enclosingNamespace string) (string, string) {
+	name, _ := schema["name"].(string)
+	if strings.Contains(name, ".") {
+		return name, name[:strings.LastIndex(name, ".")]
+	}
+	namespace := enclosingNamespace
+	if ns, ok := schema["namespace"].(string); ok {
+		namespace = ns
+	}
+	if namespace == "" {
+		return name, ""
+	}
+	return namespace + "." + name, namespace
+}
+
+// resolve returns the named type referenced by name from the namespace
+func (s *avroSchema) resolve(name, namespace string) (namedType, error) {
+	if named, ok := s.names[name]; ok {
+		return named, nil
+	}
+	if named, ok := s.names[namespace+"."+name]; ok {
+		return named, nil
+	}
+	return namedType{}, fmt.Errorf("unknown type name: %s", name)
+}
+
+// typeName returns the name goavro gives to the schema as member of an union
+func (s *avroSchema) typeName(schema interface{}, namespace string) string {
+	switch t := schema.(type) {
+	case string:
+		if named, err := s.resolve(t, namespace); err == nil {
+			name, _ := fullName(named.schema, named.namespace)
+			return name
+		}
+		return t
+	case map[string]interface{}:
+		typ, _ := t["type"].(string)
+		switch typ {
+		case "record", "enum", "fixed":
+			name, _ := fullName(t, namespace)
+			return name
+		case "array", "map":
+			return typ
+		}
+		if logicalType, ok := t["logicalType"].(string); ok && logicalTypes[typ+"."+logicalType] {
+			return typ + "." + logicalType
+		}
+		return s.typeName(t["type"], namespace)
+	}
+	return ""
+}
+
+// toNative converts the JSON of a payload into the native form of the schema
+func (s *avroSchema) toNative(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return s.valueToNative(s.schema, "", value)
+}
+
+func (s *avroSchema) valueToNative(schema interface{}, namespace string, value interface{}) (interface{}, error) {
+	switch t := schema.(type) {
+	case string:
+		return s.primitiveToNative(t, namespace, value)
+	case []interface{}:
+		if value == nil {
+			for _, member := range t {
+				if member == "null" {
+					return nil, nil
+				}
+			}
+			return nil, errors.New("null value for a non nullable union")
+		}
+		for _, member := range t {
+			if member == "null" {
+				continue
+			}
+			if native, err := s.valueToNative(member, namespace, value); err == nil {
+				return goavro.Union(s.typeName(member, namespace), native), nil
+			}
+		}
+		return nil, fmt.Errorf("value %v doesn't match any type of the union", value)
+	case map[string]interface{}:
+		return s.complexToNative(t, namespace, value)
+	}
+	return nil, fmt.Errorf("invalid schema type %T", schema)
+}
+
+func (s *avroSchema) primitiveToNative(typ, namespace string, value interface{}) (interface{}, error) {
+	switch typ {
+	case "null":
+		if value != nil {
+			return nil, fmt.Errorf("expected null, received %v", value)
+		}
+		return nil, nil
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return nil, fmt.Errorf("expected boolean, received %T", value)
+		}
+		return value, nil
+	case "string":
+		if _, ok := value.(string); !ok {
+			return nil, fmt.Errorf("expected string, received %T", value)
+		}
+		return value, nil
+	case "int", "long":
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("expected %s, received %T", typ, value)
+		}
+		return number.Int64()
+	case "float", "double":
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("expected %s, received %T", typ, value)
+		}
+		return number.Float64()
+	case "bytes":
+		// encoding/json writes the byte slices as base64 strings, and the nil ones as null
+		if value == nil {
+			return []byte{}, nil
+		}
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected bytes, received %T", value)
+		}
+		return base64.StdEncoding.DecodeString(str)
+	default:
+		named, err := s.resolve(typ, namespace)
+		if err != nil {
+			return nil, err
+		}
+		return s.complexToNative(named.schema, named.namespace, value)
+	}
+}
+
+func (s *avroSchema) complexToNative(schema map[string]interface{}, namespace string, value interface{}) (interface{}, error) {
+	typ, _ := schema["type"].(string)
+	switch typ {
+	case "record":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected record, received %T", value)
+		}
+		_, namespace = fullName(schema, namespace)
+		native := make(map[string]interface{}, len(fields))
+		for _, field := range schema["fields"].([]interface{}) {
+			f := field.(map[string]interface{})
+			name := f["name"].(string)
+			fieldValue, ok := fields[name]
+			if !ok {
+				defaultValue, hasDefault := f["default"]
+				if !hasDefault {
+					return nil, fmt.Errorf("missing field %s", name)
+				}
+				if defaultValue == nil {
+					native[name] = nil
+					continue
+				}
+				fieldValue = defaultValue
+				if number, ok := defaultValue.(float64); ok {
+					fieldValue = json.Number(fmt.Sprint(number))
+				}
+			}
+			fieldNative, err := s.valueToNative(f["type"], namespace, fieldValue)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %s", name, err.Error())
+			}
+			native[name] = fieldNative
+		}
+		return native, nil
+	case "enum":
+		if _, ok := value.(string); !ok {
+			return nil, fmt.Errorf("expected enum, received %T", value)
+		}
+		return value, nil
+	case "fixed":
+		return s.primitiveToNative("bytes", namespace, value)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			if value == nil {
+				// encoding/json writes the nil slices as null
+				return []interface{}{}, nil
+			}
+			return nil, fmt.Errorf("expected array, received %T", value)
+		}
+		native := make([]interface{}, len(items))
+		for i, item := range items {
+			itemNative, err := s.valueToNative(schema["items"], namespace, item)
+			if err != nil {
+				return nil, err
+			}
+			native[i] = itemNative
+		}
+		return native, nil
+	case "map":
+		values, ok := value.(map[string]interface{})
+		if !ok {
+			if value == nil {
+				return map[string]interface{}{}, nil
+			}
+			return nil, fmt.Errorf("expected map, received %T", value)
+		}
+		native := make(map[string]interface{}, len(values))
+		for key, val := range values {
+			valNative, err := s.valueToNative(schema["values"], namespace, val)
+			if err != nil {
+				return nil, err
+			}
+			native[key] = valNative
+		}
+		return native, nil
+	}
+	logicalType, _ := schema["logicalType"].(string)
+	switch logicalType {
+	case "timestamp-millis", "timestamp-micros", "date":
+		// goavro expects time.Time values, encoding/json writes them as RFC 3339 strings
+		switch v := value.(type) {
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, err
+			}
+			if t.IsZero() {
+				return zeroTime(logicalType), nil
+			}
+			// goavro converts the times through UnixNano, which overflows out of the years 1678 to 2262
+			if t.Before(minTime) || t.After(maxTime) {
+				return nil, fmt.Errorf("time %s out of the %s range, use a pointer for the optional times", v, logicalType)
+			}
+			return t, nil
+		case json.Number:
+			n, err := v.Int64()
+			if err != nil {
+				return nil, err
+			}
+			switch logicalType {
+			case "timestamp-millis":
+				return time.Unix(0, n*int64(time.Millisecond)).UTC(), nil
+			case "timestamp-micros":
+				return time.Unix(0, n*int64(time.Microsecond)).UTC(), nil
+			default:
+				return time.Unix(n*int64(24*time.Hour/time.Second), 0).UTC(), nil
+			}
+		}
+		return nil, fmt.Errorf("expected %s, received %T", logicalType, value)
+	}
+	return s.valueToNative(schema["type"], namespace, value)
+}
+
+/*
+fromNative converts a value in the native form of the schema into a value marshalled by encoding/json
+as the payload was, unwrapping the unions
+*/
+func (s *avroSchema) fromNative(native interface{}) (interface{}, error) {
+	return s.nativeToValue(s.schema, "", native)
+}
+
+func (s *avroSchema) nativeToValue(schema interface{}, namespace string, native interface{}) (interface{}, error) {
+	switch t := schema.(type) {
+	case string:
+		switch t {
+		case "null", "boolean", "string", "int", "long", "float", "double", "bytes":
+			return native, nil
+		}
+		named, err := s.resolve(t, namespace)
+		if err != nil {
+			return nil, err
+		}
+		return s.nativeToValue(named.schema, named.namespace, native)
+	case []interface{}:
+		if native == nil {
+			return nil, nil
+		}
+		union, ok := native.(map[string]interface{})
+		if !ok || len(union) != 1 {
+			return nil, fmt.Errorf("expected union, received %T", native)
+		}
+		for name, value := range union {
+			for _, member := range t {
+				if s.typeName(member, namespace) == name {
+					return s.nativeToValue(member, namespace, value)
+				}
+			}
+			return nil, fmt.Errorf("unknown union type %s", name)
+		}
+	case map[string]interface{}:
+		typ, _ := t["type"].(string)
+		switch typ {
+		case "record":
+			fields, ok := native.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("expected record, received %T", native)
+			}
+			_, namespace = fullName(t, namespace)
+			value := make(map[string]interface{}, len(fields))
+			for _, field := range t["fields"].([]interface{}) {
+				f := field.(map[string]interface{})
+				name := f["name"].(string)
+				fieldValue, err := s.nativeToValue(f["type"], namespace, fields[name])
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %s", name, err.Error())
+				}
+				value[name] = fieldValue
+			}
+			return value, nil
+		case "array":
+			items, _ := native.([]interface{})
+			value := make([]interface{}, len(items))
+			for i, item := range items {
+				itemValue, err := s.nativeToValue(t["items"], namespace, item)
+				if err != nil {
+					return nil, err
+				}
+				value[i] = itemValue
+			}
+			return value, nil
+		case "map":
+			values, _ := native.(map[string]interface{})
+			value := make(map[string]interface{}, len(values))
+			for key, val := range values {
+				valValue, err := s.nativeToValue(t["values"], namespace, val)
+				if err != nil {
+					return nil, err
+				}
+				value[key] = valValue
+			}
+			return value, nil
+		case "enum", "fixed":
+			return native, nil
+		}
+		// The logical types are native time.Time values, marshalled by encoding/json as RFC 3339 strings
+		if logicalType, _ := t["logicalType"].(string); timeUnits[logicalType] != 0 {
+			if native, ok := native.(time.Time); ok && native.Equal(zeroTime(logicalType)) {
+				return time.Time{}, nil
+			}
+		}
+		return native, nil
+	}
+	return nil, fmt.Errorf("invalid schema type %T", schema)
+}
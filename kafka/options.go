@@ -0,0 +1,199 @@
+package kafka
+
+import (
+	ckafka "github.com/confluentinc/confluent-kafka-go/kafka"
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/internal/router"
+	"strings"
+)
+
+// Option configures the construction of a publisher or a subscriber
+type Option func(*options)
+
+type options struct {
+	config                ckafka.ConfigMap
+	producer              *ckafka.Producer
+	schemaRegistryClient  *CachedSchemaRegistryClient
+	schemaRegistryOptions []RegistryOption
+	compatibilityCheck    bool
+	compatibilityLevel    CompatibilityLevel
+	subjectNameStrategy   SubjectNameStrategy
+	logger                queuesgo.Logger
+	retryPolicy           *queuesgo.RetryPolicy
+	deadLetter            queuesgo.Publisher
+	unknownEventPolicy    queuesgo.UnknownEventPolicy
+	logMode               bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		config:              ckafka.ConfigMap{},
+		subjectNameStrategy: TopicNameStrategy,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.logger == nil {
+		level := queuesgo.LevelInfo
+		if o.logMode {
+			level = queuesgo.LevelDebug
+		}
+		o.logger = queuesgo.NewDefaultLogger(level)
+	}
+	return o
+}
+
+// routerConfig returns the options handled by the router of the subscriber reading from the subscription
+func (o *options) routerConfig(subscription string) router.Config {
+	config := router.Config{
+		Logger:             o.logger,
+		RetryPolicy:        o.retryPolicy,
+		UnknownEventPolicy: o.unknownEventPolicy,
+	}
+	if o.deadLetter != nil {
+		config.DeadLetter = &queuesgo.DeadLetterQueue{Publisher: o.deadLetter, Subscription: subscription}
+	}
+	return config
+}
+
+/*
+configMap returns the defaults overridden by the configured properties, with the given ones set on top
+as the publisher and the subscriber depend on them
+*/
+func (o *options) configMap(defaults, properties ckafka.ConfigMap) *ckafka.ConfigMap {
+	config := make(ckafka.ConfigMap, len(defaults)+len(o.config)+len(properties))
+	for key, value := range defaults {
+		config[key] = value
+	}
+	for key, value := range o.config {
+		config[key] = value
+	}
+	for key, value := range properties {
+		config[key] = value
+	}
+	return &config
+}
+
+// cachedSchemaRegistryClient returns the given client or creates a new one for the comma separated addresses
+func (o *options) cachedSchemaRegistryClient(schemaServerAddress string) (*CachedSchemaRegistryClient, error) {
+	if o.schemaRegistryClient != nil {
+		return o.schemaRegistryClient, nil
+	}
+	return NewCachedSchemaRegistryClientWithOptions(strings.Split(schemaServerAddress, ","), o.schemaRegistryOptions...)
+}
+
+// WithConfig sets extra librdkafka properties (security protocol, SASL credentials...) on the producer or consumer
+func WithConfig(config ckafka.ConfigMap) Option {
+	return func(o *options) {
+		for key, value := range config {
+			o.config[key] = value
+		}
+	}
+}
+
+// WithProducer uses an existing producer instead of creating a new one, the Kafka hosts are ignored by the publisher
+func WithProducer(producer *ckafka.Producer) Option {
+	return func(o *options) {
+		o.producer = producer
+	}
+}
+
+// WithSchemaRegistryClient uses an existing schema registry client, the schema server address is ignored
+func WithSchemaRegistryClient(client *CachedSchemaRegistryClient) Option {
+	return func(o *options) {
+		o.schemaRegistryClient = client
+	}
+}
+
+// WithSchemaRegistryRetries sets the amount of retries of the schema registry client on 5XX responses
+func WithSchemaRegistryRetries(retries int) Option {
+	return func(o *options) {
+		o.schemaRegistryOptions = append(o.schemaRegistryOptions, WithRegistryRetries(retries))
+	}
+}
+
+/*
+Creates the schema registry client with the given options, to set the authentication, TLS and timeout of the requests
+Ignored when an existing client is given with WithSchemaRegistryClient
+*/
+func WithSchemaRegistryOptions(opts ...RegistryOption) Option {
+	return func(o *options) {
+		o.schemaRegistryOptions = append(o.schemaRegistryOptions, opts...)
+	}
+}
+
+/*
+Checks when the publisher is created that the generated schema is compatible with the latest version of the subject,
+failing with the incompatibility reasons given by the schema registry instead of on the first publication
+*/
+func WithCompatibilityCheck() Option {
+	return func(o *options) {
+		o.compatibilityCheck = true
+	}
+}
+
+/*
+Sets the compatibility level of the subject of the publisher when it is created, if the subject has a different one
+It is set before the check of WithCompatibilityCheck, so the schema is tested against the given level
+*/
+func WithCompatibilityLevel(level CompatibilityLevel) Option {
+	return func(o *options) {
+		o.compatibilityLevel = level
+	}
+}
+
+// WithSubjectNameStrategy sets how the publisher names the subject of its schema, TopicNameStrategy by default
+func WithSubjectNameStrategy(strategy SubjectNameStrategy) Option {
+	return func(o *options) {
+		o.subjectNameStrategy = strategy
+	}
+}
+
+// WithLogger writes the logs to the given logger instead of the standard error
+func WithLogger(logger queuesgo.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+/*
+Enables the logging of every received message and handled event on the default logger
+Deprecated: use WithLogger with a logger on debug level
+*/
+func WithLogMode(logMode bool) Option {
+	return func(o *options) {
+		o.logMode = logMode
+	}
+}
+
+/*
+Calls again the handler of an event not acknowledged, following the given policy, before leaving it to the queue provider
+The handlers are retried in process, so the message is held by the subscriber while waiting
+*/
+func WithRetryPolicy(policy queuesgo.RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = &policy
+	}
+}
+
+/*
+Republishes through the given publisher the events not acknowledged once the retries are exhausted,
+acknowledging the original message. The events carry the dead letter attributes on the extra metadata
+The Kafka publishers only take a struct type, so with typed handlers of different payload types the dead letter
+publisher must be of another provider created with a map[string]interface{} type
+*/
+func WithDeadLetter(publisher queuesgo.Publisher) Option {
+	return func(o *options) {
+		o.deadLetter = publisher
+	}
+}
+
+/*
+Sets what the subscriber does with the events without a registered handler nor a fallback, acknowledging them by default
+DeadLetterUnknownEvents requires WithDeadLetter, the subscriber cannot be created otherwise
+*/
+func WithUnknownEventPolicy(policy queuesgo.UnknownEventPolicy) Option {
+	return func(o *options) {
+		o.unknownEventPolicy = policy
+	}
+}
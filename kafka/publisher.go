@@ -8,17 +8,39 @@ import (
 	ckafka "github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/linkedin/goavro/v2"
 	queuesgo "github.com/merlinapp/queues-go"
-	"log"
+	"github.com/merlinapp/queues-go/internal/inflight"
 	"reflect"
-	"strings"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// flushTimeoutMs is the time given to each flush of the producer while closing the publisher
+const flushTimeoutMs = 100
+
 type publisher struct {
 	producer             *ckafka.Producer
+	ownProducer          bool // the producer was created by the publisher, so it is closed with it
 	schemaRegistryClient *CachedSchemaRegistryClient
 	topic                string
-	schema               string
+	subject              string
+	avroCodec            *goavro.Codec
+	avroSchema           *avroSchema
+	schemaId             int32 // registered id of the schema, zero until the first publication registers it
+	registerLock         sync.Mutex
+	registering          *registration // registration of the schema in progress, nil if there is none
 	objectType           reflect.Type
+	publications         inflight.Publications
+	closed               chan struct{} // closed once Close gives up waiting, releasing the publications without report
+	logger               queuesgo.Logger
+}
+
+// registration is the registration of the schema shared by the publications waiting for the schema id
+type registration struct {
+	done chan struct{} // closed once id and err are set
+	id   int
+	err  error
 }
 
 /*
@@ -28,32 +50,105 @@ the objectType interface should be any of the following types, any other type wi
 1. Copy of a structure
 2. Non-nil pointer to a struct of the expected type.
 If the structure doesn't have json tags, the schema will follow the literal fields names.
+Deprecated: use NewPublisherWithOptions, which returns the cause of the failure
 */
 func NewPublisher(kafkaServerHosts, schemaServerAddress, topic string, objectType interface{}) queuesgo.Publisher {
-	if !queuesgo.ValidateType(objectType) {
+	logger := queuesgo.NewDefaultLogger(queuesgo.LevelInfo)
+	p, err := NewPublisherWithOptions(kafkaServerHosts, schemaServerAddress, topic, objectType, WithLogger(logger))
+	if err != nil {
+		logger.Error("Could not create avro producer", "topic", topic, "error", err)
 		return nil
 	}
-	schemaRegistryClient := NewCachedSchemaRegistryClient(strings.Split(schemaServerAddress, ","))
+	return p
+}
 
-	schema := createSchema(queuesgo.GetName(objectType), queuesgo.GetFields(objectType))
-	schemaBytes, err := json.Marshal(schema)
+/*
+Creates a new Kafka publisher
+the kafkaServerAddresses string can receive several hosts separated by ','
+the schemaServerAddress string can receive several schema registry addresses separated by ','
+the objectType interface should be any of the following types, any other type will return an error
+1. Copy of a structure
+2. Non-nil pointer to a struct of the expected type.
+If the structure doesn't have json tags, the schema will follow the literal fields names.
+The schema is registered on the subject by the first publication, and by the next ones until it succeeds.
+Returns an error if the schema cannot be generated or the producer cannot be created,
+or an IncompatibleSchemaError if WithCompatibilityCheck is given and the schema is not compatible with the registry,
+or the error of the schema registry if WithCompatibilityLevel is given and the level cannot be set
+The publisher is a queuesgo.BatchPublisher, a queuesgo.ClosablePublisher and a queuesgo.TypedPublisher,
+closing it closes the producer unless it was given with WithProducer
+*/
+func NewPublisherWithOptions(kafkaServerHosts, schemaServerAddress, topic string, objectType interface{}, opts ...Option) (queuesgo.Publisher, error) {
+	if !queuesgo.ValidateType(objectType) || !isStruct(objectType) {
+		return nil, errors.New("invalid object type")
+	}
+	o := newOptions(opts)
+	schemaRegistryClient, err := o.cachedSchemaRegistryClient(schemaServerAddress)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	fmt.Println("Schema registered: " + string(schemaBytes))
 
-	producer, err := ckafka.NewProducer(&ckafka.ConfigMap{"bootstrap.servers": kafkaServerHosts})
+	schema, err := createSchema(queuesgo.GetName(objectType), queuesgo.GetFields(objectType))
 	if err != nil {
-		log.Printf("Could not create avro producer: %s", err)
-		return nil
+		return nil, err
+	}
+	schemaBytes, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := goavro.NewCodec(string(schemaBytes))
+	if err != nil {
+		return nil, err
+	}
+	subject := o.subjectNameStrategy(topic, false, schema.Name)
+	// The startup requests are bounded by the timeout and retries of the schema registry client
+	ctx := context.Background()
+	if o.compatibilityLevel != "" {
+		if err = enforceCompatibilityLevel(ctx, schemaRegistryClient, subject, o.compatibilityLevel); err != nil {
+			return nil, err
+		}
+	}
+	if o.compatibilityCheck {
+		if err = checkCompatibility(ctx, schemaRegistryClient, subject, codec); err != nil {
+			return nil, err
+		}
+	}
+	parsedSchema, err := parseAvroSchema(string(schemaBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	producer := o.producer
+	if producer == nil {
+		producer, err = ckafka.NewProducer(o.configMap(nil, ckafka.ConfigMap{"bootstrap.servers": kafkaServerHosts}))
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &publisher{
 		producer:             producer,
+		ownProducer:          o.producer == nil,
 		schemaRegistryClient: schemaRegistryClient,
 		topic:                topic,
-		schema:               string(schemaBytes),
+		subject:              subject,
+		avroCodec:            codec,
+		avroSchema:           parsedSchema,
 		objectType:           reflect.TypeOf(objectType),
+		closed:               make(chan struct{}),
+		logger:               o.logger,
+	}, nil
+}
+
+// isStruct reports if the object type is a struct or a pointer to one, the only types with an Avro schema
+func isStruct(objectType interface{}) bool {
+	t := reflect.TypeOf(objectType)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
+	return t.Kind() == reflect.Struct
+}
+
+func (p *publisher) AcceptsPayload(payload interface{}) bool {
+	return queuesgo.ValidateRegisteredType(payload, p.objectType)
 }
 
 func (p *publisher) PublishSync(ctx context.Context, event *queuesgo.Event) (string, error) {
@@ -61,7 +156,12 @@ func (p *publisher) PublishSync(ctx context.Context, event *queuesgo.Event) (str
 	if err != nil {
 		return "", err
 	}
-	return p.sendMessage([]byte(event.Metadata.ObjectID), data, headers)
+	if err = p.publications.Add(1); err != nil {
+		return "", err
+	}
+	result, err := p.sendMessage(ctx, []byte(event.Metadata.ObjectID), data, headers)
+	p.publications.Done(err)
+	return result, err
 }
 
 func (p *publisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
@@ -69,68 +169,290 @@ func (p *publisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-
 	if err != nil {
 		return nil, err
 	}
+	if err = p.publications.Add(1); err != nil {
+		return nil, err
+	}
 	res := make(chan queuesgo.PublicationResult, 1)
 	go func() {
-		result, err := p.sendMessage([]byte(event.Metadata.ObjectID), data, headers)
-		p := queuesgo.PublicationResult{Result: result, Err: err}
-		res <- p
+		result, err := p.sendMessage(ctx, []byte(event.Metadata.ObjectID), data, headers)
+		p.publications.Done(err)
+		res <- queuesgo.PublicationResult{Result: result, Err: err}
 		close(res)
 	}()
-	return res, err
+	return res, nil
+}
+
+/*
+Flushes the messages queued on the producer and waits for their delivery reports until the context is done,
+then closes the producer if it was created by the publisher
+The delivery reports not received on time are lost, their publications end with ErrPublisherClosed
+*/
+func (p *publisher) Close(ctx context.Context) error {
+	if err := p.publications.Close(); err != nil {
+		return err
+	}
+	idle := p.publications.Idle()
+	for p.publications.Pending() > 0 && ctx.Err() == nil {
+		if p.producer.Flush(flushTimeoutMs) > 0 {
+			continue
+		}
+		// Nothing left to flush, the pending publications may be waiting for the schema registry
+		timer := time.NewTimer(flushTimeoutMs * time.Millisecond)
+		select {
+		case <-idle:
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	errs := p.publications.Wait(ctx)
+	close(p.closed)
+	if p.ownProducer {
+		p.producer.Close()
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+/*
+checkCompatibility fails with an IncompatibleSchemaError if the codec is not compatible with the latest version
+of the subject, the subjects without versions accept any schema
+*/
+func checkCompatibility(ctx context.Context, client *CachedSchemaRegistryClient, subject string, codec *goavro.Codec) error {
+	result, err := client.TestCompatibility(ctx, subject, codec, LatestVersion)
+	if err != nil {
+		var registryErr *Error
+		if errors.As(err, &registryErr) &&
+			(registryErr.ErrorCode == ErrorCodeSubjectNotFound || registryErr.ErrorCode == ErrorCodeVersionNotFound) {
+			return nil
+		}
+		return err
+	}
+	if !result.IsCompatible {
+		return &IncompatibleSchemaError{Subject: subject, Messages: result.Messages}
+	}
+	return nil
 }
 
-//GetSchemaId get schema id from schema-registry service
-func (p *publisher) getSchemaId(avroCodec *goavro.Codec) (int, error) {
-	schemaId, err := p.schemaRegistryClient.CreateSubject(p.topic+"-value", avroCodec)
+// enforceCompatibilityLevel sets the level on the subject unless it already has it
+func enforceCompatibilityLevel(ctx context.Context, client *CachedSchemaRegistryClient, subject string, level CompatibilityLevel) error {
+	if !level.Valid() {
+		return errors.New("invalid compatibility level")
+	}
+	current, err := client.GetCompatibilityLevel(ctx, subject)
 	if err != nil {
-		return 0, err
+		var registryErr *Error
+		if !errors.As(err, &registryErr) || (registryErr.ErrorCode != ErrorCodeSubjectNotFound &&
+			registryErr.ErrorCode != ErrorCodeSubjectLevelCompatibilityNotConfigured) {
+			return err
+		}
+	}
+	if current == level {
+		return nil
+	}
+	return client.SetCompatibilityLevel(ctx, subject, level)
+}
+
+/*
+Returns the id of the schema, registering it on the first call
+A single registration runs at a time, without holding the lock, the other publications wait for it until their
+context is done, and try again by themselves if it was given up by the context of its caller
+*/
+func (p *publisher) getSchemaId(ctx context.Context) (int, error) {
+	for {
+		if schemaId := atomic.LoadInt32(&p.schemaId); schemaId != 0 {
+			return int(schemaId), nil
+		}
+		p.registerLock.Lock()
+		if schemaId := atomic.LoadInt32(&p.schemaId); schemaId != 0 {
+			p.registerLock.Unlock()
+			return int(schemaId), nil
+		}
+		r := p.registering
+		if r == nil {
+			r = &registration{done: make(chan struct{})}
+			p.registering = r
+			p.registerLock.Unlock()
+			p.register(ctx, r)
+			return r.id, r.err
+		}
+		p.registerLock.Unlock()
+		select {
+		case <-r.done:
+			if r.err == nil {
+				return r.id, nil
+			}
+			if !errors.Is(r.err, context.Canceled) && !errors.Is(r.err, context.DeadlineExceeded) {
+				return 0, r.err
+			}
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
 	}
-	return schemaId, nil
 }
 
-func (p *publisher) sendMessage(key []byte, value []byte, headers []ckafka.Header) (string, error) {
-	avroCodec, err := goavro.NewCodec(p.schema)
-	schemaId, err := p.getSchemaId(avroCodec)
+// register registers the schema on the subject, releasing the publications waiting for it
+func (p *publisher) register(ctx context.Context, r *registration) {
+	r.id, r.err = p.schemaRegistryClient.CreateSubject(ctx, p.subject, p.avroCodec)
+	p.registerLock.Lock()
+	if r.err == nil {
+		atomic.StoreInt32(&p.schemaId, int32(r.id))
+		p.logger.Info("Schema registered", "topic", p.topic, "subject", p.subject, "id", r.id, "schema", p.avroCodec.Schema())
+	}
+	p.registering = nil
+	p.registerLock.Unlock()
+	close(r.done)
+}
+
+func (p *publisher) sendMessage(ctx context.Context, key []byte, value []byte, headers []ckafka.Header) (string, error) {
+	schemaId, err := p.getSchemaId(ctx)
 	if err != nil {
 		return "", err
 	}
-	native, _, err := avroCodec.NativeFromTextual(value)
+	message, err := p.encodeMessage(schemaId, value)
 	if err != nil {
 		return "", err
 	}
-	// Convert native Go form to binary Avro data
-	binaryValue, err := avroCodec.BinaryFromNative(nil, native)
+	deliveryChan := make(chan ckafka.Event, 1)
+	err = p.produce(key, message, headers, nil, deliveryChan)
 	if err != nil {
+		// The message was not queued, so there won't be a delivery report
 		return "", err
 	}
+	queuesgo.RecordMessageSize(ctx, len(message))
+	select {
+	case e := <-deliveryChan:
+		return deliveryResult(e)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-p.closed:
+		return "", queuesgo.ErrPublisherClosed
+	}
+}
+
+/*
+Validates and encodes every event before getting the schema id, then queues all the messages on the producer
+and waits for their delivery reports until the context is done, the reports not received on time end with its error
+*/
+func (p *publisher) PublishBatch(ctx context.Context, events []*queuesgo.Event) ([]queuesgo.PublicationResult, error) {
+	type pendingMessage struct {
+		key     []byte
+		value   []byte
+		headers []ckafka.Header
+	}
+	messages := make([]pendingMessage, len(events))
+	for i, event := range events {
+		data, headers, err := p.eventToKafka(event)
+		if err == nil {
+			data, err = p.avroBinary(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		messages[i] = pendingMessage{key: []byte(event.Metadata.ObjectID), value: data, headers: headers}
+	}
+	schemaId, err := p.getSchemaId(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		avroEncoder := &AvroEncoder{SchemaID: schemaId, Content: messages[i].value}
+		if messages[i].value, err = avroEncoder.Encode(); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+	if err = p.publications.Add(len(messages)); err != nil {
+		return nil, err
+	}
 
+	results := make([]queuesgo.PublicationResult, len(messages))
+	deliveryChan := make(chan ckafka.Event, len(messages))
+	produced := 0
+	for i, message := range messages {
+		// The index travels as the opaque of the message to match the delivery reports with the events
+		err = p.produce(message.key, message.value, message.headers, i, deliveryChan)
+		if err != nil {
+			results[i].Err = err
+			p.publications.Done(err)
+			continue
+		}
+		queuesgo.RecordMessageSize(ctx, len(message.value))
+		produced++
+	}
+	for ; produced > 0; produced-- {
+		select {
+		case e := <-deliveryChan:
+			i := e.(*ckafka.Message).Opaque.(int)
+			results[i].Result, results[i].Err = deliveryResult(e)
+			p.publications.Done(results[i].Err)
+		case <-ctx.Done():
+			p.undelivered(results, produced, ctx.Err())
+			return results, nil
+		case <-p.closed:
+			p.undelivered(results, produced, queuesgo.ErrPublisherClosed)
+			return results, nil
+		}
+	}
+	return results, nil
+}
+
+// undelivered ends with the error the n produced messages of the batch still without result
+func (p *publisher) undelivered(results []queuesgo.PublicationResult, n int, err error) {
+	for i := range results {
+		if n == 0 {
+			return
+		}
+		if results[i].Result == "" && results[i].Err == nil {
+			results[i].Err = err
+			p.publications.Done(results[i].Err)
+			n--
+		}
+	}
+}
+
+// encodeMessage converts the JSON value to the avro binary form prefixed by the schema id
+func (p *publisher) encodeMessage(schemaId int, value []byte) ([]byte, error) {
+	binaryValue, err := p.avroBinary(value)
+	if err != nil {
+		return nil, err
+	}
 	avrEncoder := &AvroEncoder{
 		SchemaID: schemaId,
 		Content:  binaryValue,
 	}
+	return avrEncoder.Encode()
+}
 
-	message, _ := avrEncoder.Encode()
-	deliveryChan := make(chan ckafka.Event)
+// avroBinary converts the JSON value to the avro binary form, failing if it doesn't match the schema
+func (p *publisher) avroBinary(value []byte) ([]byte, error) {
+	native, err := p.avroSchema.toNative(value)
+	if err != nil {
+		return nil, err
+	}
+	// Convert native Go form to binary Avro data
+	return p.avroCodec.BinaryFromNative(nil, native)
+}
 
-	err = p.producer.Produce(&ckafka.Message{
+func (p *publisher) produce(key, value []byte, headers []ckafka.Header, opaque interface{}, deliveryChan chan ckafka.Event) error {
+	return p.producer.Produce(&ckafka.Message{
 		TopicPartition: ckafka.TopicPartition{Topic: &p.topic, Partition: ckafka.PartitionAny},
 		Key:            key,
-		Value:          message,
+		Value:          value,
 		Headers:        headers,
+		Opaque:         opaque,
 	}, deliveryChan)
+}
 
-	e := <-deliveryChan
+// deliveryResult returns the confirmation message of a delivery report, or its error
+func deliveryResult(e ckafka.Event) (string, error) {
 	m := e.(*ckafka.Message)
-
-	var msg string
 	if m.TopicPartition.Error != nil {
-		err = m.TopicPartition.Error
-	} else {
-		msg = fmt.Sprintf("Delivered message to topic %s [%d] at offset %v\n",
-			*m.TopicPartition.Topic, m.TopicPartition.Partition, m.TopicPartition.Offset)
+		return "", m.TopicPartition.Error
 	}
-	close(deliveryChan)
-	return msg, err
+	return fmt.Sprintf("Delivered message to topic %s [%d] at offset %v\n",
+		*m.TopicPartition.Topic, m.TopicPartition.Partition, m.TopicPartition.Offset), nil
 }
 
 func (p *publisher) eventToKafka(event *queuesgo.Event) ([]byte, []ckafka.Header, error) {
@@ -141,26 +463,21 @@ func (p *publisher) eventToKafka(event *queuesgo.Event) ([]byte, []ckafka.Header
 	if err != nil {
 		return nil, nil, errors.New("invalid payload")
 	}
-	headers := make([]ckafka.Header, 5)
-	headers[0] = ckafka.Header{
-		Key:   "correlation_id",
-		Value: []byte(event.Metadata.CorrelationID),
-	}
-	headers[1] = ckafka.Header{
-		Key:   "event_name",
-		Value: []byte(event.Metadata.EventName),
-	}
-	headers[2] = ckafka.Header{
-		Key:   "origin",
-		Value: []byte(event.Metadata.Origin),
+	if event.Metadata.IsZero() {
+		return nil, nil, errors.New("invalid metadata")
 	}
-	headers[3] = ckafka.Header{
-		Key:   "object_id",
-		Value: []byte(event.Metadata.ObjectID),
+	attributes := event.Metadata.Attributes()
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
 	}
-	headers[4] = ckafka.Header{
-		Key:   "timestamp",
-		Value: []byte(string(event.Metadata.Timestamp)),
+	sort.Strings(keys)
+	headers := make([]ckafka.Header, 0, len(keys))
+	for _, key := range keys {
+		headers = append(headers, ckafka.Header{
+			Key:   key,
+			Value: []byte(attributes[key]),
+		})
 	}
 	return data, headers, nil
 }
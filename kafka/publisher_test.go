@@ -0,0 +1,261 @@
+package kafka_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	ckafka "github.com/confluentinc/confluent-kafka-go/kafka"
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/kafka"
+	"github.com/merlinapp/queues-go/kafka/registrytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableBroker accepts the messages on the producer queue but never delivers them
+const unreachableBroker = "127.0.0.1:1"
+
+type order struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+func newEvent(objectID string) *queuesgo.Event {
+	return &queuesgo.Event{
+		Payload: order{ID: objectID, Total: 10},
+		Metadata: queuesgo.EventMetadata{
+			CorrelationID: "correlation-" + objectID,
+			EventName:     "order_created",
+			Origin:        "test",
+			Timestamp:     1600000000000,
+			ObjectID:      objectID,
+		},
+	}
+}
+
+// recordingLogger keeps the messages logged with the info level
+type recordingLogger struct {
+	mu    sync.Mutex
+	infos []string
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, msg)
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+func (l *recordingLogger) messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.infos...)
+}
+
+// newPublisher creates a publisher on the unreachable broker using a fake schema registry
+func newPublisher(t testing.TB, opts ...kafka.Option) (queuesgo.Publisher, *registrytest.Server) {
+	t.Helper()
+	server := registrytest.NewServer()
+	t.Cleanup(server.Close)
+	opts = append([]kafka.Option{kafka.WithConfig(ckafka.ConfigMap{"log_level": 0})}, opts...)
+	publisher, err := kafka.NewPublisherWithOptions(unreachableBroker, server.URL, "orders", order{}, opts...)
+	require.NoError(t, err)
+	return publisher, server
+}
+
+// closePublisher closes the publisher without waiting for the messages that the unreachable broker never delivers
+func closePublisher(t testing.TB, publisher queuesgo.Publisher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_ = publisher.(queuesgo.ClosablePublisher).Close(ctx)
+}
+
+func TestNewPublisherWithOptionsInvalidObjectTypes(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	for name, objectType := range map[string]interface{}{
+		"map":            map[string]interface{}{},
+		"pointer to map": &map[string]interface{}{},
+		"string":         "order",
+	} {
+		t.Run(name, func(t *testing.T) {
+			publisher, err := kafka.NewPublisherWithOptions(unreachableBroker, server.URL, "orders", objectType)
+			assert.EqualError(t, err, "invalid object type")
+			assert.Nil(t, publisher)
+		})
+	}
+}
+
+func TestCloseReleasesThePublicationsWithoutDeliveryReport(t *testing.T) {
+	publisher, _ := newPublisher(t)
+	first, err := publisher.PublishAsync(context.Background(), newEvent("1"))
+	require.NoError(t, err)
+	second, err := publisher.PublishAsync(context.Background(), newEvent("2"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err = publisher.(queuesgo.ClosablePublisher).Close(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+
+	for _, res := range []<-chan queuesgo.PublicationResult{first, second} {
+		select {
+		case result := <-res:
+			assert.Equal(t, queuesgo.ErrPublisherClosed, result.Err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the publication was not released")
+		}
+		_, open := <-res
+		assert.False(t, open)
+	}
+	_, err = publisher.PublishSync(context.Background(), newEvent("3"))
+	assert.Equal(t, queuesgo.ErrPublisherClosed, err)
+}
+
+func TestCloseWaitsForThePublicationsRegisteringTheSchema(t *testing.T) {
+	publisher, server := newPublisher(t)
+	server.SetLatency(200 * time.Millisecond)
+	published := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		_, err := publisher.PublishSync(ctx, newEvent("1"))
+		published <- err
+	}()
+	require.Eventually(t, func() bool {
+		return len(server.Requests()) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := publisher.(queuesgo.ClosablePublisher).Close(ctx)
+
+	// The publication registers the schema and ends with its context while waiting for the delivery report
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, context.DeadlineExceeded, <-published)
+}
+
+func TestPublishBatchValidatesTheEventsBeforeRegisteringTheSchema(t *testing.T) {
+	publisher, server := newPublisher(t)
+	server.ResetRequests()
+	invalid := newEvent("2")
+	invalid.Metadata.EventName = ""
+
+	_, err := queuesgo.PublishBatch(context.Background(), publisher, []*queuesgo.Event{newEvent("1"), invalid})
+
+	assert.EqualError(t, err, "event 1: invalid metadata")
+	assert.Empty(t, server.Requests())
+}
+
+func TestPublishBatchStopsWaitingWhenTheContextIsDone(t *testing.T) {
+	publisher, _ := newPublisher(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	results, err := queuesgo.PublishBatch(ctx, publisher, []*queuesgo.Event{newEvent("1"), newEvent("2")})
+
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.Equal(t, context.DeadlineExceeded, result.Err)
+	}
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer closeCancel()
+	assert.NoError(t, publisher.(queuesgo.ClosablePublisher).Close(closeCtx))
+}
+
+func TestPublicationsWaitForTheSchemaRegistrationUntilTheirContextIsDone(t *testing.T) {
+	logger := &recordingLogger{}
+	publisher, server := newPublisher(t, kafka.WithLogger(logger))
+	defer closePublisher(t, publisher)
+	assert.Empty(t, logger.messages())
+	server.ResetRequests()
+	server.SetLatency(300 * time.Millisecond)
+
+	registered := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+		defer cancel()
+		_, err := queuesgo.PublishBatch(ctx, publisher, []*queuesgo.Event{newEvent("1")})
+		registered <- err
+	}()
+	require.Eventually(t, func() bool {
+		return len(server.Requests()) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := publisher.PublishSync(ctx, newEvent("2"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(250*time.Millisecond))
+
+	assert.NoError(t, <-registered)
+	assert.Equal(t, []string{"POST /subjects/orders-value/versions"}, server.Requests())
+	assert.Equal(t, []string{"Schema registered"}, logger.messages())
+}
+
+func TestPublicationsRegisterTheSchemaWhenTheRegistrationIsGivenUp(t *testing.T) {
+	publisher, server := newPublisher(t)
+	defer closePublisher(t, publisher)
+	server.ResetRequests()
+	server.SetLatency(200 * time.Millisecond)
+
+	givenUp := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := publisher.PublishSync(ctx, newEvent("1"))
+		givenUp <- err
+	}()
+	require.Eventually(t, func() bool {
+		return len(server.Requests()) == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	results, err := queuesgo.PublishBatch(ctx, publisher, []*queuesgo.Event{newEvent("2")})
+	require.NoError(t, err)
+	assert.Equal(t, context.DeadlineExceeded, results[0].Err)
+	assert.Equal(t, context.DeadlineExceeded, <-givenUp)
+	assert.Len(t, server.Requests(), 2)
+}
+
+/*
+The context is cancelled so the publications don't wait for the delivery reports that the unreachable broker
+never sends, measuring the validation, the encoding and the queueing of the messages
+*/
+func BenchmarkPublishSync(b *testing.B) {
+	publisher, _ := newPublisher(b, kafka.WithLogger(&recordingLogger{}), kafka.WithConfig(ckafka.ConfigMap{
+		"queue.buffering.max.messages": 10000000,
+		"queue.buffering.max.kbytes":   2097151,
+	}))
+	defer closePublisher(b, publisher)
+	// The schema is registered before measuring
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := publisher.PublishSync(ctx, newEvent("0"))
+	require.Equal(b, context.DeadlineExceeded, err)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	event := newEvent("1")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := publisher.PublishSync(cancelled, event); err != context.Canceled {
+			b.Fatal(err)
+		}
+	}
+}
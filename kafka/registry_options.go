@@ -0,0 +1,170 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// RegistryOption configures the construction of a schema registry client
+type RegistryOption func(*registryOptions)
+
+/*
+Returns the token sent as bearer on the Authorization header of every request to the schema registry
+It is called on each request, so it should cache the token until it expires
+*/
+type TokenProvider func(ctx context.Context) (string, error)
+
+type registryOptions struct {
+	httpClient    *http.Client
+	timeout       time.Duration
+	retries       int // negative to retry once per server
+	username      string
+	password      string
+	tokenProvider TokenProvider
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	cooldown      time.Duration
+	rootCAs       [][]byte
+	certificates  []tls.Certificate
+	tlsConfig     *tls.Config
+	errs          []error
+}
+
+func newRegistryOptions(opts []RegistryOption) *registryOptions {
+	o := &registryOptions{
+		timeout:    timeout,
+		retries:    -1,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		cooldown:   serverCooldown,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// client returns the given http client or creates one with the timeout and TLS options
+func (o *registryOptions) client() (*http.Client, error) {
+	if len(o.errs) > 0 {
+		return nil, o.errs[0]
+	}
+	if o.username != "" && o.tokenProvider != nil {
+		return nil, errors.New("invalid schema registry authentication, both basic and bearer are set")
+	}
+	if o.httpClient != nil {
+		return o.httpClient, nil
+	}
+	client := &http.Client{Timeout: o.timeout}
+	if o.tlsConfig == nil && len(o.rootCAs) == 0 && len(o.certificates) == 0 {
+		return client, nil
+	}
+	tlsConfig := &tls.Config{}
+	if o.tlsConfig != nil {
+		tlsConfig = o.tlsConfig.Clone()
+	}
+	if len(o.rootCAs) > 0 {
+		pool := x509.NewCertPool()
+		for _, ca := range o.rootCAs {
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, errors.New("invalid schema registry CA certificate")
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+	tlsConfig.Certificates = append(tlsConfig.Certificates, o.certificates...)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client.Transport = transport
+	return client, nil
+}
+
+/*
+Uses the given http client for the requests to the schema registry
+The timeout and TLS options are ignored, they must be set on the client
+*/
+func WithRegistryHTTPClient(client *http.Client) RegistryOption {
+	return func(o *registryOptions) {
+		o.httpClient = client
+	}
+}
+
+// WithRegistryTimeout sets the timeout of each request to the schema registry, 2 seconds by default
+func WithRegistryTimeout(timeout time.Duration) RegistryOption {
+	return func(o *registryOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithRegistryRetries sets the amount of retries on 5XX responses and http errors, once per server by default
+func WithRegistryRetries(retries int) RegistryOption {
+	return func(o *registryOptions) {
+		o.retries = retries
+	}
+}
+
+/*
+Sets the delay before the first retry of a failed request, doubled on each retry up to the maximum,
+a random jitter of up to half the delay is subtracted. 100 milliseconds up to 2 seconds by default
+*/
+func WithRegistryBackoff(initial, max time.Duration) RegistryOption {
+	return func(o *registryOptions) {
+		o.backoff = initial
+		o.maxBackoff = max
+	}
+}
+
+/*
+Sets the time a server is tried after the healthy ones once a request to it fails, 30 seconds by default
+Zero disables it, every request starts by a random server
+*/
+func WithRegistryServerCooldown(cooldown time.Duration) RegistryOption {
+	return func(o *registryOptions) {
+		o.cooldown = cooldown
+	}
+}
+
+// WithRegistryBasicAuth authenticates the requests to the schema registry with the given credentials
+func WithRegistryBasicAuth(username, password string) RegistryOption {
+	return func(o *registryOptions) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithRegistryBearerToken authenticates the requests to the schema registry with the tokens of the provider
+func WithRegistryBearerToken(provider TokenProvider) RegistryOption {
+	return func(o *registryOptions) {
+		o.tokenProvider = provider
+	}
+}
+
+// WithRegistryCA trusts the PEM encoded CA certificates to verify the schema registry instead of the system ones
+func WithRegistryCA(pemCerts []byte) RegistryOption {
+	return func(o *registryOptions) {
+		o.rootCAs = append(o.rootCAs, pemCerts)
+	}
+}
+
+// WithRegistryClientCertificate presents the PEM encoded certificate and key to the schema registry, for mutual TLS
+func WithRegistryClientCertificate(certPEM, keyPEM []byte) RegistryOption {
+	return func(o *registryOptions) {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			o.errs = append(o.errs, err)
+			return
+		}
+		o.certificates = append(o.certificates, cert)
+	}
+}
+
+// WithRegistryTLSConfig sets the base TLS configuration of the requests, the CA and client certificate options are added to it
+func WithRegistryTLSConfig(config *tls.Config) RegistryOption {
+	return func(o *registryOptions) {
+		o.tlsConfig = config
+	}
+}
@@ -0,0 +1,248 @@
+package kafka_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/merlinapp/queues-go/kafka"
+	"github.com/merlinapp/queues-go/kafka/registrytest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const unrelatedSchema = `{"type": "record", "name": "unrelated", "fields": [{"name": "name", "type": "string"}]}`
+
+func newRegistryClient(t *testing.T, server *registrytest.Server, opts ...kafka.RegistryOption) *kafka.SchemaRegistryClient {
+	t.Helper()
+	client, err := kafka.NewSchemaRegistryClientWithOptions([]string{server.URL}, opts...)
+	require.NoError(t, err)
+	return client
+}
+
+func registryErrorCode(t *testing.T, err error) int {
+	t.Helper()
+	var registryErr *kafka.Error
+	require.True(t, errors.As(err, &registryErr), err)
+	return registryErr.ErrorCode
+}
+
+func TestRegistryClientRetriesServerErrors(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	client := newRegistryClient(t, server, kafka.WithRegistryRetries(2), kafka.WithRegistryBackoff(time.Millisecond, time.Millisecond))
+
+	server.FailNext(2, 503)
+	_, err := client.GetSubjects(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, server.Requests(), 3)
+
+	server.ResetRequests()
+	server.FailNext(3, 500)
+	_, err = client.GetSubjects(context.Background())
+	assert.Equal(t, 50001, registryErrorCode(t, err))
+	assert.Len(t, server.Requests(), 3)
+}
+
+func TestRegistryClientDoesNotRetryClientErrors(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	client := newRegistryClient(t, server, kafka.WithRegistryRetries(2), kafka.WithRegistryBackoff(time.Millisecond, time.Millisecond))
+
+	_, err := client.GetSchema(context.Background(), 99)
+	assert.Equal(t, kafka.ErrorCodeSchemaNotFound, registryErrorCode(t, err))
+	server.FailNext(1, 422)
+	_, err = client.GetSubjects(context.Background())
+	assert.Equal(t, 50001, registryErrorCode(t, err))
+	assert.Len(t, server.Requests(), 2)
+}
+
+func TestRegistryClientBackoff(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	client := newRegistryClient(t, server, kafka.WithRegistryRetries(2), kafka.WithRegistryBackoff(40*time.Millisecond, 40*time.Millisecond))
+	server.FailNext(2, 503)
+
+	start := time.Now()
+	_, err := client.GetSubjects(context.Background())
+
+	require.NoError(t, err)
+	// every retry waits at least half the delay
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+}
+
+func TestRegistryClientStopsOnTheContext(t *testing.T) {
+	server := registrytest.NewServer(registrytest.WithLatency(5 * time.Second))
+	defer server.Close()
+	client := newRegistryClient(t, server, kafka.WithRegistryRetries(2))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetSubjects(ctx)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Len(t, server.Requests(), 1)
+}
+
+func TestRegistryClientBasicAuth(t *testing.T) {
+	server := registrytest.NewServer(registrytest.WithBasicAuth("user", "secret"))
+	defer server.Close()
+
+	_, err := newRegistryClient(t, server, kafka.WithRegistryBasicAuth("user", "secret")).GetSubjects(context.Background())
+	assert.NoError(t, err)
+	_, err = newRegistryClient(t, server, kafka.WithRegistryBasicAuth("user", "wrong")).GetSubjects(context.Background())
+	assert.Equal(t, 401, registryErrorCode(t, err))
+	_, err = newRegistryClient(t, server).GetSubjects(context.Background())
+	assert.Equal(t, 401, registryErrorCode(t, err))
+}
+
+func TestRegistryClientBearerToken(t *testing.T) {
+	server := registrytest.NewServer(registrytest.WithBearerToken("token"))
+	defer server.Close()
+	calls := 0
+	client := newRegistryClient(t, server, kafka.WithRegistryBearerToken(func(ctx context.Context) (string, error) {
+		calls++
+		return "token", nil
+	}))
+
+	_, err := client.GetSubjects(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	_, err = newRegistryClient(t, server, kafka.WithRegistryBearerToken(func(ctx context.Context) (string, error) {
+		return "expired", nil
+	})).GetSubjects(context.Background())
+	assert.Equal(t, 401, registryErrorCode(t, err))
+	_, err = newRegistryClient(t, server, kafka.WithRegistryBearerToken(func(ctx context.Context) (string, error) {
+		return "", errors.New("no token")
+	})).GetSubjects(context.Background())
+	assert.EqualError(t, err, "no token")
+}
+
+// otherCA returns a self signed certificate that didn't sign the certificate of the test servers
+func otherCA(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestRegistryClientCA(t *testing.T) {
+	server := registrytest.NewTLSServer()
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	_, err := newRegistryClient(t, server, kafka.WithRegistryCA(ca)).GetSubjects(context.Background())
+	assert.NoError(t, err)
+	_, err = newRegistryClient(t, server, kafka.WithRegistryRetries(0), kafka.WithRegistryCA(otherCA(t))).GetSubjects(context.Background())
+	assert.True(t, errors.As(err, &x509.UnknownAuthorityError{}), err)
+	_, err = newRegistryClient(t, server, kafka.WithRegistryRetries(0)).GetSubjects(context.Background())
+	assert.True(t, errors.As(err, &x509.UnknownAuthorityError{}), err)
+
+	_, err = kafka.NewSchemaRegistryClientWithOptions([]string{server.URL}, kafka.WithRegistryCA([]byte("not a certificate")))
+	assert.EqualError(t, err, "invalid schema registry CA certificate")
+}
+
+func TestCachedRegistryClientCachesBySubject(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	client, err := kafka.NewCachedSchemaRegistryClientWithOptions([]string{server.URL})
+	require.NoError(t, err)
+	codec, err := goavro.NewCodec(unrelatedSchema)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	id, err := client.CreateSubject(ctx, "first-value", codec)
+	require.NoError(t, err)
+	cached, err := client.CreateSubject(ctx, "first-value", codec)
+	require.NoError(t, err)
+	assert.Equal(t, id, cached)
+	// the same schema is registered again on another subject, getting the same id
+	other, err := client.CreateSubject(ctx, "second-value", codec)
+	require.NoError(t, err)
+	assert.Equal(t, id, other)
+	assert.Equal(t, []string{"POST /subjects/first-value/versions", "POST /subjects/second-value/versions"}, server.Requests())
+
+	server.ResetRequests()
+	_, err = client.GetSchema(ctx, id)
+	require.NoError(t, err)
+	_, err = client.GetSchema(ctx, id)
+	require.NoError(t, err)
+	assert.Len(t, server.Requests(), 1)
+}
+
+func TestRegistryClientCompatibility(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	client := newRegistryClient(t, server)
+	ctx := context.Background()
+
+	level, err := client.GetGlobalCompatibilityLevel(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, kafka.CompatibilityBackward, level)
+	require.NoError(t, client.SetGlobalCompatibilityLevel(ctx, kafka.CompatibilityFull))
+	level, err = client.GetGlobalCompatibilityLevel(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, kafka.CompatibilityFull, level)
+
+	_, err = client.GetCompatibilityLevel(ctx, "orders-value")
+	assert.Equal(t, kafka.ErrorCodeSubjectLevelCompatibilityNotConfigured, registryErrorCode(t, err))
+	require.NoError(t, client.SetCompatibilityLevel(ctx, "orders-value", kafka.CompatibilityNone))
+	level, err = client.GetCompatibilityLevel(ctx, "orders-value")
+	require.NoError(t, err)
+	assert.Equal(t, kafka.CompatibilityNone, level)
+
+	_, err = server.Register("users-value", unrelatedSchema)
+	require.NoError(t, err)
+	codec, err := goavro.NewCodec(`{"type": "record", "name": "unrelated", "fields": [{"name": "age", "type": "int"}]}`)
+	require.NoError(t, err)
+	result, err := client.TestCompatibility(ctx, "users-value", codec, kafka.LatestVersion)
+	require.NoError(t, err)
+	assert.False(t, result.IsCompatible)
+	assert.NotEmpty(t, result.Messages)
+	_, err = client.TestCompatibility(ctx, "missing-value", codec, kafka.LatestVersion)
+	assert.Equal(t, kafka.ErrorCodeSubjectNotFound, registryErrorCode(t, err))
+}
+
+func TestPublisherCompatibilityOptions(t *testing.T) {
+	server := registrytest.NewServer()
+	defer server.Close()
+	_, err := server.Register("orders-value", unrelatedSchema)
+	require.NoError(t, err)
+
+	_, err = kafka.NewPublisherWithOptions(unreachableBroker, server.URL, "orders", order{}, kafka.WithCompatibilityCheck())
+	var incompatible *kafka.IncompatibleSchemaError
+	require.True(t, errors.As(err, &incompatible), err)
+	assert.Equal(t, "orders-value", incompatible.Subject)
+	assert.NotEmpty(t, incompatible.Messages)
+
+	publisher, err := kafka.NewPublisherWithOptions(unreachableBroker, server.URL, "orders", order{},
+		kafka.WithCompatibilityLevel(kafka.CompatibilityNone), kafka.WithCompatibilityCheck())
+	require.NoError(t, err)
+	defer closePublisher(t, publisher)
+	client := newRegistryClient(t, server)
+	level, err := client.GetCompatibilityLevel(context.Background(), "orders-value")
+	require.NoError(t, err)
+	assert.Equal(t, kafka.CompatibilityNone, level)
+}
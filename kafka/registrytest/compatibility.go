@@ -0,0 +1,135 @@
+package registrytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+/*
+Checks if the data written with the writer schema can be read with the reader schema
+Returns the reasons of the incompatibility, empty if they are compatible
+*/
+type CompatibilityChecker func(reader, writer string) []string
+
+// promotions are the writer primitive types readable as other types, following the Avro schema resolution
+var promotions = map[string][]string{
+	"int":    {"long", "float", "double"},
+	"long":   {"float", "double"},
+	"float":  {"double"},
+	"string": {"bytes"},
+	"bytes":  {"string"},
+}
+
+/*
+CheckFields is a simplified Avro schema resolution, enough for the schemas generated by the Kafka publisher
+The fields of the records are matched by name, the reader fields missing on the writer need a default,
+the primitive types can be promoted, the unions are resolved by branch and the other types must be equal
+*/
+func CheckFields(reader, writer string) []string {
+	var readerSchema, writerSchema interface{}
+	if err := json.Unmarshal([]byte(reader), &readerSchema); err != nil {
+		return []string{"invalid reader schema: " + err.Error()}
+	}
+	if err := json.Unmarshal([]byte(writer), &writerSchema); err != nil {
+		return []string{"invalid writer schema: " + err.Error()}
+	}
+	return checkType("", readerSchema, writerSchema)
+}
+
+func checkType(path string, reader, writer interface{}) []string {
+	readerRecord, readerIsRecord := record(reader)
+	writerRecord, writerIsRecord := record(writer)
+	if readerIsRecord && writerIsRecord {
+		return checkRecord(path, readerRecord, writerRecord)
+	}
+	if reflect.DeepEqual(reader, writer) {
+		return nil
+	}
+	if writerUnion, ok := writer.([]interface{}); ok {
+		// every branch the writer may use must be readable
+		var messages []string
+		for _, branch := range writerUnion {
+			messages = append(messages, checkType(path, reader, branch)...)
+		}
+		return messages
+	}
+	if readerUnion, ok := reader.([]interface{}); ok {
+		for _, branch := range readerUnion {
+			if len(checkType(path, branch, writer)) == 0 {
+				return nil
+			}
+		}
+	}
+	readerName, readerIsPrimitive := primitive(reader)
+	writerName, writerIsPrimitive := primitive(writer)
+	if readerIsPrimitive && writerIsPrimitive {
+		for _, promoted := range promotions[writerName] {
+			if promoted == readerName {
+				return nil
+			}
+		}
+	}
+	readerJSON, _ := json.Marshal(reader)
+	writerJSON, _ := json.Marshal(writer)
+	return []string{fmt.Sprintf("%s: reader type %s doesn't match writer type %s", fieldPath(path), readerJSON, writerJSON)}
+}
+
+func checkRecord(path string, reader, writer map[string]interface{}) []string {
+	writerFields := make(map[string]interface{})
+	for _, field := range fields(writer) {
+		writerFields[fmt.Sprint(field["name"])] = field["type"]
+	}
+	var messages []string
+	for _, field := range fields(reader) {
+		name := fmt.Sprint(field["name"])
+		writerType, found := writerFields[name]
+		if !found {
+			if _, hasDefault := field["default"]; !hasDefault {
+				messages = append(messages, fmt.Sprintf("%s: reader field without default missing on the writer", fieldPath(path+"/"+name)))
+			}
+			continue
+		}
+		messages = append(messages, checkType(path+"/"+name, field["type"], writerType)...)
+	}
+	return messages
+}
+
+func record(schema interface{}) (map[string]interface{}, bool) {
+	object, ok := schema.(map[string]interface{})
+	return object, ok && object["type"] == "record"
+}
+
+func fields(schema map[string]interface{}) []map[string]interface{} {
+	list, _ := schema["fields"].([]interface{})
+	result := make([]map[string]interface{}, 0, len(list))
+	for _, field := range list {
+		if object, ok := field.(map[string]interface{}); ok {
+			result = append(result, object)
+		}
+	}
+	return result
+}
+
+// primitive returns the name of a primitive type, written as a string or as an object without logical type
+func primitive(schema interface{}) (string, bool) {
+	switch t := schema.(type) {
+	case string:
+		return t, true
+	case map[string]interface{}:
+		if _, logical := t["logicalType"]; logical {
+			return "", false
+		}
+		name, ok := t["type"].(string)
+		_, isPromotable := promotions[name]
+		return name, ok && isPromotable
+	}
+	return "", false
+}
+
+func fieldPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
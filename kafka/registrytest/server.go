@@ -0,0 +1,540 @@
+// Package registrytest runs an in-process Confluent schema registry to test the Kafka publishers and subscribers
+package registrytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"github.com/merlinapp/queues-go/kafka"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error codes answered by the schema registry
+const (
+	errorCodeSubjectNotFound      = 40401
+	errorCodeVersionNotFound      = 40402
+	errorCodeSchemaNotFound       = 40403
+	errorCodeSubjectLevelNotFound = 40408
+	errorCodeIncompatibleSchema   = 409
+	errorCodeInvalidSchema        = 42201
+	errorCodeInvalidVersion       = 42202
+	errorCodeInvalidCompatibility = 42203
+	errorCodeUnauthorized         = 401
+	errorCodeInjectedFailure      = 50001
+)
+
+const (
+	defaultGlobalCompatibilityLevel = kafka.CompatibilityBackward
+	contentType                     = "application/vnd.schemaregistry.v1+json"
+	maxRequestsKept                 = 10000
+)
+
+// Option configures the construction of a server
+type Option func(*Server)
+
+/*
+Server is a fake schema registry serving the endpoints used by kafka.SchemaRegistryClient from memory
+The embedded httptest.Server gives its URL and must be closed at the end of the test
+*/
+type Server struct {
+	*httptest.Server
+	lock          sync.Mutex
+	nextID        int
+	schemas       map[int]string // by id
+	ids           map[string]int // by compacted schema
+	subjects      map[string][]version
+	globalLevel   kafka.CompatibilityLevel
+	subjectLevels map[string]kafka.CompatibilityLevel
+	checker       CompatibilityChecker
+	latency       time.Duration
+	failures      int
+	failureStatus int
+	username      string
+	password      string
+	token         string
+	requests      []string
+}
+
+type version struct {
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+	Schema  string `json:"schema"`
+	ID      int    `json:"id"`
+}
+
+// WithCompatibilityLevel sets the global compatibility level, BACKWARD by default as the schema registry
+func WithCompatibilityLevel(level kafka.CompatibilityLevel) Option {
+	return func(s *Server) {
+		s.globalLevel = level
+	}
+}
+
+// WithCompatibilityChecker replaces the check of the schemas against the registered versions, CheckFields by default
+func WithCompatibilityChecker(checker CompatibilityChecker) Option {
+	return func(s *Server) {
+		s.checker = checker
+	}
+}
+
+// WithLatency delays every response by the given duration
+func WithLatency(latency time.Duration) Option {
+	return func(s *Server) {
+		s.latency = latency
+	}
+}
+
+// WithBasicAuth rejects with 401 the requests without the given credentials
+func WithBasicAuth(username, password string) Option {
+	return func(s *Server) {
+		s.username = username
+		s.password = password
+	}
+}
+
+// WithBearerToken rejects with 401 the requests without the given bearer token
+func WithBearerToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// NewServer starts a fake schema registry listening on plain http
+func NewServer(opts ...Option) *Server {
+	s := newServer(opts)
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer starts a fake schema registry listening on https, its certificate is given by Certificate
+func NewTLSServer(opts ...Option) *Server {
+	s := newServer(opts)
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func newServer(opts []Option) *Server {
+	s := &Server{
+		nextID:        1,
+		schemas:       make(map[int]string),
+		ids:           make(map[string]int),
+		subjects:      make(map[string][]version),
+		globalLevel:   defaultGlobalCompatibilityLevel,
+		subjectLevels: make(map[string]kafka.CompatibilityLevel),
+		checker:       CheckFields,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+/*
+Answers the next n requests with the given status, a 5XX status makes the client retry them
+The failed requests are recorded by Requests as any other
+*/
+func (s *Server) FailNext(n int, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failures = n
+	s.failureStatus = status
+}
+
+// SetLatency delays every following response by the given duration
+func (s *Server) SetLatency(latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.latency = latency
+}
+
+// Requests returns the method and path of the requests received, as "GET /schemas/ids/1"
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// ResetRequests forgets the requests received so far
+func (s *Server) ResetRequests() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests = nil
+}
+
+/*
+Registers the schema on the subject as the register endpoint, without checking the compatibility
+Returns the id of the schema, the same for equal schemas across subjects
+*/
+func (s *Server) Register(subject, schema string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key, err := compactSchema(schema)
+	if err != nil {
+		return 0, err
+	}
+	return s.register(subject, schema, key).ID, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	if len(s.requests) > maxRequestsKept {
+		s.requests = s.requests[1:]
+	}
+	latency := s.latency
+	fail := s.failures > 0
+	status := s.failureStatus
+	if fail {
+		s.failures--
+	}
+	s.lock.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if fail {
+		writeError(w, status, errorCodeInjectedFailure, "injected failure")
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, errorCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(segments) == 3 && segments[0] == "schemas" && segments[1] == "ids" && r.Method == http.MethodGet:
+		s.getSchema(w, segments[2])
+	case len(segments) == 1 && segments[0] == "subjects" && r.Method == http.MethodGet:
+		s.getSubjects(w)
+	case len(segments) == 2 && segments[0] == "subjects" && r.Method == http.MethodPost:
+		s.lookup(w, r, segments[1])
+	case len(segments) == 2 && segments[0] == "subjects" && r.Method == http.MethodDelete:
+		s.deleteSubject(w, segments[1])
+	case len(segments) == 3 && segments[0] == "subjects" && segments[2] == "versions" && r.Method == http.MethodGet:
+		s.getVersions(w, segments[1])
+	case len(segments) == 3 && segments[0] == "subjects" && segments[2] == "versions" && r.Method == http.MethodPost:
+		s.registerVersion(w, r, segments[1])
+	case len(segments) == 4 && segments[0] == "subjects" && segments[2] == "versions" && r.Method == http.MethodGet:
+		s.getVersion(w, segments[1], segments[3])
+	case len(segments) == 4 && segments[0] == "subjects" && segments[2] == "versions" && r.Method == http.MethodDelete:
+		s.deleteVersion(w, segments[1], segments[3])
+	case len(segments) == 5 && segments[0] == "compatibility" && segments[1] == "subjects" && segments[3] == "versions" &&
+		r.Method == http.MethodPost:
+		s.testCompatibility(w, r, segments[2], segments[4])
+	case len(segments) == 1 && segments[0] == "config":
+		s.config(w, r, "")
+	case len(segments) == 2 && segments[0] == "config":
+		s.config(w, r, segments[1])
+	default:
+		writeError(w, http.StatusNotFound, http.StatusNotFound, "HTTP 404 Not Found")
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token != "" {
+		return r.Header.Get("Authorization") == "Bearer "+s.token
+	}
+	if s.username != "" {
+		username, password, ok := r.BasicAuth()
+		return ok && username == s.username && password == s.password
+	}
+	return true
+}
+
+func (s *Server) getSchema(w http.ResponseWriter, idParam string) {
+	id, err := strconv.Atoi(idParam)
+	schema, found := s.schemas[id]
+	if err != nil || !found {
+		writeError(w, http.StatusNotFound, errorCodeSchemaNotFound, "Schema not found")
+		return
+	}
+	writeJSON(w, map[string]string{"schema": schema})
+}
+
+func (s *Server) getSubjects(w http.ResponseWriter) {
+	subjects := make([]string, 0, len(s.subjects))
+	for subject := range s.subjects {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	writeJSON(w, subjects)
+}
+
+func (s *Server) getVersions(w http.ResponseWriter, subject string) {
+	versions, found := s.subjects[subject]
+	if !found {
+		writeError(w, http.StatusNotFound, errorCodeSubjectNotFound, fmt.Sprintf("Subject '%s' not found.", subject))
+		return
+	}
+	numbers := make([]int, len(versions))
+	for i, v := range versions {
+		numbers[i] = v.Version
+	}
+	writeJSON(w, numbers)
+}
+
+func (s *Server) getVersion(w http.ResponseWriter, subject, versionParam string) {
+	v, ok := s.findVersion(w, subject, versionParam)
+	if ok {
+		writeJSON(w, v)
+	}
+}
+
+// findVersion returns the version of the subject, a number or latest, writing the error if it doesn't exist
+func (s *Server) findVersion(w http.ResponseWriter, subject, versionParam string) (version, bool) {
+	versions, found := s.subjects[subject]
+	if !found {
+		writeError(w, http.StatusNotFound, errorCodeSubjectNotFound, fmt.Sprintf("Subject '%s' not found.", subject))
+		return version{}, false
+	}
+	if versionParam == kafka.LatestVersion {
+		return versions[len(versions)-1], true
+	}
+	number, err := strconv.Atoi(versionParam)
+	if err != nil || number <= 0 {
+		writeError(w, http.StatusUnprocessableEntity, errorCodeInvalidVersion, "The specified version is not a valid version id.")
+		return version{}, false
+	}
+	for _, v := range versions {
+		if v.Version == number {
+			return v, true
+		}
+	}
+	writeError(w, http.StatusNotFound, errorCodeVersionNotFound, fmt.Sprintf("Version %d not found.", number))
+	return version{}, false
+}
+
+func (s *Server) registerVersion(w http.ResponseWriter, r *http.Request, subject string) {
+	schema, key, ok := readSchema(w, r)
+	if !ok {
+		return
+	}
+	if id, found := s.ids[key]; found {
+		for _, v := range s.subjects[subject] {
+			if v.ID == id {
+				writeJSON(w, map[string]int{"id": id})
+				return
+			}
+		}
+	}
+	if messages := s.incompatibilities(subject, schema, s.subjects[subject]); len(messages) > 0 {
+		writeError(w, http.StatusConflict, errorCodeIncompatibleSchema,
+			"Schema being registered is incompatible with an earlier schema; "+strings.Join(messages, "; "))
+		return
+	}
+	writeJSON(w, map[string]int{"id": s.register(subject, schema, key).ID})
+}
+
+// register adds the schema as a new version of the subject, reusing the id of an equal schema
+func (s *Server) register(subject, schema, key string) version {
+	versions := s.subjects[subject]
+	id, found := s.ids[key]
+	for _, v := range versions {
+		if found && v.ID == id {
+			return v
+		}
+	}
+	if !found {
+		id = s.nextID
+		s.nextID++
+		s.ids[key] = id
+		s.schemas[id] = schema
+	}
+	number := 1
+	if len(versions) > 0 {
+		number = versions[len(versions)-1].Version + 1
+	}
+	v := version{Subject: subject, Version: number, Schema: schema, ID: id}
+	s.subjects[subject] = append(versions, v)
+	return v
+}
+
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request, subject string) {
+	_, key, ok := readSchema(w, r)
+	if !ok {
+		return
+	}
+	versions, found := s.subjects[subject]
+	if !found {
+		writeError(w, http.StatusNotFound, errorCodeSubjectNotFound, fmt.Sprintf("Subject '%s' not found.", subject))
+		return
+	}
+	id, found := s.ids[key]
+	for _, v := range versions {
+		if found && v.ID == id {
+			writeJSON(w, v)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, errorCodeSchemaNotFound, "Schema not found")
+}
+
+func (s *Server) deleteSubject(w http.ResponseWriter, subject string) {
+	versions, found := s.subjects[subject]
+	if !found {
+		writeError(w, http.StatusNotFound, errorCodeSubjectNotFound, fmt.Sprintf("Subject '%s' not found.", subject))
+		return
+	}
+	numbers := make([]int, len(versions))
+	for i, v := range versions {
+		numbers[i] = v.Version
+	}
+	delete(s.subjects, subject)
+	writeJSON(w, numbers)
+}
+
+func (s *Server) deleteVersion(w http.ResponseWriter, subject, versionParam string) {
+	deleted, ok := s.findVersion(w, subject, versionParam)
+	if !ok {
+		return
+	}
+	versions := s.subjects[subject]
+	kept := make([]version, 0, len(versions)-1)
+	for _, v := range versions {
+		if v.Version != deleted.Version {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == 0 {
+		delete(s.subjects, subject)
+	} else {
+		s.subjects[subject] = kept
+	}
+	writeJSON(w, deleted.Version)
+}
+
+func (s *Server) testCompatibility(w http.ResponseWriter, r *http.Request, subject, versionParam string) {
+	schema, _, ok := readSchema(w, r)
+	if !ok {
+		return
+	}
+	v, ok := s.findVersion(w, subject, versionParam)
+	if !ok {
+		return
+	}
+	versions := []version{v}
+	if versionParam == kafka.LatestVersion && strings.HasSuffix(string(s.level(subject)), "_TRANSITIVE") {
+		versions = s.subjects[subject]
+	}
+	messages := s.incompatibilities(subject, schema, versions)
+	writeJSON(w, kafka.CompatibilityResult{IsCompatible: len(messages) == 0, Messages: messages})
+}
+
+// incompatibilities checks the schema against the versions following the level of the subject
+func (s *Server) incompatibilities(subject, schema string, versions []version) []string {
+	level := s.level(subject)
+	if level == kafka.CompatibilityNone || len(versions) == 0 {
+		return nil
+	}
+	if !strings.HasSuffix(string(level), "_TRANSITIVE") {
+		versions = versions[len(versions)-1:]
+	}
+	var messages []string
+	for _, v := range versions {
+		if level != kafka.CompatibilityForward && level != kafka.CompatibilityForwardTransitive {
+			// the new schema must read the data written with the previous ones
+			messages = append(messages, s.checker(schema, v.Schema)...)
+		}
+		if level != kafka.CompatibilityBackward && level != kafka.CompatibilityBackwardTransitive {
+			// the previous schemas must read the data written with the new one
+			messages = append(messages, s.checker(v.Schema, schema)...)
+		}
+	}
+	return messages
+}
+
+func (s *Server) level(subject string) kafka.CompatibilityLevel {
+	if level, found := s.subjectLevels[subject]; found {
+		return level
+	}
+	return s.globalLevel
+}
+
+// config reads or updates the global compatibility level, or the one of the subject if given
+func (s *Server) config(w http.ResponseWriter, r *http.Request, subject string) {
+	switch r.Method {
+	case http.MethodGet:
+		level := s.globalLevel
+		if subject != "" {
+			var found bool
+			level, found = s.subjectLevels[subject]
+			if !found {
+				writeError(w, http.StatusNotFound, errorCodeSubjectLevelNotFound,
+					fmt.Sprintf("Subject '%s' does not have subject-level compatibility configured", subject))
+				return
+			}
+		}
+		writeJSON(w, map[string]kafka.CompatibilityLevel{"compatibilityLevel": level})
+	case http.MethodPut:
+		var request struct {
+			Compatibility kafka.CompatibilityLevel `json:"compatibility"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil || !request.Compatibility.Valid() {
+			writeError(w, http.StatusUnprocessableEntity, errorCodeInvalidCompatibility, "Invalid compatibility level")
+			return
+		}
+		if subject == "" {
+			s.globalLevel = request.Compatibility
+		} else {
+			s.subjectLevels[subject] = request.Compatibility
+		}
+		writeJSON(w, request)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, http.StatusMethodNotAllowed, "HTTP 405 Method Not Allowed")
+	}
+}
+
+// readSchema returns the schema of the request body and its compacted form, writing the error if it is not valid
+func readSchema(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	var request struct {
+		Schema string `json:"schema"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err == nil {
+		_, err = goavro.NewCodec(request.Schema)
+	}
+	var key string
+	if err == nil {
+		key, err = compactSchema(request.Schema)
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, errorCodeInvalidSchema, "Invalid schema: "+err.Error())
+		return "", "", false
+	}
+	return request.Schema, key, true
+}
+
+// compactSchema returns the schema without whitespaces, so equal schemas get the same id
+func compactSchema(schema string) (string, error) {
+	var value interface{}
+	err := json.Unmarshal([]byte(schema), &value)
+	if err != nil {
+		return "", err
+	}
+	compacted, err := json.Marshal(value)
+	return string(compacted), err
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(kafka.Error{ErrorCode: code, Message: message})
+}
@@ -6,6 +6,7 @@ package kafka
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/linkedin/goavro/v2"
@@ -13,20 +14,27 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
 // SchemaRegistryClientInterface defines the api for all clients interfacing with schema registry
 type SchemaRegistryClientInterface interface {
-	GetSchema(int) (*goavro.Codec, error)
-	GetSubjects() ([]string, error)
-	GetVersions(string) ([]int, error)
-	GetSchemaByVersion(string, int) (*goavro.Codec, error)
-	GetLatestSchema(string) (*goavro.Codec, error)
-	CreateSubject(string, *goavro.Codec) (int, error)
-	IsSchemaRegistered(string, *goavro.Codec) (int, error)
-	DeleteSubject(string) error
-	DeleteVersion(string, int) error
+	GetSchema(context.Context, int) (*goavro.Codec, error)
+	GetSubjects(context.Context) ([]string, error)
+	GetVersions(context.Context, string) ([]int, error)
+	GetSchemaByVersion(context.Context, string, int) (*goavro.Codec, error)
+	GetLatestSchema(context.Context, string) (*goavro.Codec, error)
+	CreateSubject(context.Context, string, *goavro.Codec) (int, error)
+	IsSchemaRegistered(context.Context, string, *goavro.Codec) (int, error)
+	DeleteSubject(context.Context, string) error
+	DeleteVersion(context.Context, string, int) error
+	TestCompatibility(context.Context, string, *goavro.Codec, string) (*CompatibilityResult, error)
+	GetGlobalCompatibilityLevel(context.Context) (CompatibilityLevel, error)
+	SetGlobalCompatibilityLevel(context.Context, CompatibilityLevel) error
+	GetCompatibilityLevel(context.Context, string) (CompatibilityLevel, error)
+	SetCompatibilityLevel(context.Context, string, CompatibilityLevel) error
 }
 
 // SchemaRegistryClient is a basic http client to interact with schema registry
@@ -34,6 +42,14 @@ type SchemaRegistryClient struct {
 	SchemaRegistryConnect []string
 	httpClient            *http.Client
 	retries               int
+	username              string
+	password              string
+	tokenProvider         TokenProvider
+	backoff               time.Duration
+	maxBackoff            time.Duration
+	cooldown              time.Duration
+	unhealthy             map[string]time.Time // servers failed recently, until the end of their cooldown
+	unhealthyLock         sync.Mutex
 }
 
 type schemaResponse struct {
@@ -51,40 +67,108 @@ type idResponse struct {
 	ID int `json:"id"`
 }
 
+// CompatibilityResult is the answer of the schema registry to a compatibility test
+type CompatibilityResult struct {
+	IsCompatible bool     `json:"is_compatible"`
+	Messages     []string `json:"messages"` // reasons of the incompatibility, only sent by the recent schema registry versions
+}
+
+// CompatibilityLevel is the rule followed by the schema registry to accept a new version of a subject
+type CompatibilityLevel string
+
+// Compatibility levels of the schema registry
+const (
+	CompatibilityNone               CompatibilityLevel = "NONE"
+	CompatibilityBackward           CompatibilityLevel = "BACKWARD"
+	CompatibilityBackwardTransitive CompatibilityLevel = "BACKWARD_TRANSITIVE"
+	CompatibilityForward            CompatibilityLevel = "FORWARD"
+	CompatibilityForwardTransitive  CompatibilityLevel = "FORWARD_TRANSITIVE"
+	CompatibilityFull               CompatibilityLevel = "FULL"
+	CompatibilityFullTransitive     CompatibilityLevel = "FULL_TRANSITIVE"
+)
+
+// Valid reports if the level is one of the levels known by the schema registry
+func (l CompatibilityLevel) Valid() bool {
+	switch l {
+	case CompatibilityNone, CompatibilityBackward, CompatibilityBackwardTransitive, CompatibilityForward,
+		CompatibilityForwardTransitive, CompatibilityFull, CompatibilityFullTransitive:
+		return true
+	}
+	return false
+}
+
+// the schema registry answers with compatibilityLevel on reads and compatibility on updates
+type configResponse struct {
+	CompatibilityLevel CompatibilityLevel `json:"compatibilityLevel,omitempty"`
+	Compatibility      CompatibilityLevel `json:"compatibility,omitempty"`
+}
+
 const (
 	schemaByID       = "/schemas/ids/%d"
 	subjects         = "/subjects"
 	subjectVersions  = "/subjects/%s/versions"
 	deleteSubject    = "/subjects/%s"
 	subjectByVersion = "/subjects/%s/versions/%s"
+	compatibility    = "/compatibility/subjects/%s/versions/%s?verbose=true"
+	globalConfig     = "/config"
+	subjectConfig    = "/config/%s"
 
-	latestVersion = "latest"
+	// LatestVersion refers to the highest version of a subject
+	LatestVersion = "latest"
 
 	contentType = "application/vnd.schemaregistry.v1+json"
 
-	timeout = 2 * time.Second
+	timeout        = 2 * time.Second
+	backoff        = 100 * time.Millisecond
+	maxBackoff     = 2 * time.Second
+	serverCooldown = 30 * time.Second
 )
 
 // NewSchemaRegistryClient creates a client to talk with the schema registry at the connect string
 // By default it will retry failed requests (5XX responses and http errors) len(connect) number of times
 func NewSchemaRegistryClient(connect []string) *SchemaRegistryClient {
-	client := &http.Client{
-		Timeout: timeout,
-	}
-	return &SchemaRegistryClient{connect, client, len(connect)}
+	client, _ := NewSchemaRegistryClientWithOptions(connect)
+	return client
 }
 
 // NewSchemaRegistryClientWithRetries creates an http client with a configurable amount of retries on 5XX responses
 func NewSchemaRegistryClientWithRetries(connect []string, retries int) *SchemaRegistryClient {
-	client := &http.Client{
-		Timeout: timeout,
+	client, _ := NewSchemaRegistryClientWithOptions(connect, WithRegistryRetries(retries))
+	return client
+}
+
+/*
+NewSchemaRegistryClientWithOptions creates a client to talk with the schema registry at the connect string
+with the given authentication, TLS, timeout and retries
+Returns an error if the certificates cannot be parsed or both basic and bearer authentication are set
+*/
+func NewSchemaRegistryClientWithOptions(connect []string, opts ...RegistryOption) (*SchemaRegistryClient, error) {
+	o := newRegistryOptions(opts)
+	httpClient, err := o.client()
+	if err != nil {
+		return nil, err
 	}
-	return &SchemaRegistryClient{connect, client, retries}
+	retries := o.retries
+	if retries < 0 {
+		retries = len(connect)
+	}
+	return &SchemaRegistryClient{
+		SchemaRegistryConnect: connect,
+		httpClient:            httpClient,
+		retries:               retries,
+		username:              o.username,
+		password:              o.password,
+		tokenProvider:         o.tokenProvider,
+		backoff:               o.backoff,
+		maxBackoff:            o.maxBackoff,
+		cooldown:              o.cooldown,
+		unhealthy:             make(map[string]time.Time),
+	}, nil
 }
 
 // GetSchema returns a goavro.Codec by unique id
-func (client *SchemaRegistryClient) GetSchema(id int) (*goavro.Codec, error) {
-	resp, err := client.httpCall("GET", fmt.Sprintf(schemaByID, id), nil)
+func (client *SchemaRegistryClient) GetSchema(ctx context.Context, id int) (*goavro.Codec, error) {
+	resp, err := client.httpCall(ctx, "GET", fmt.Sprintf(schemaByID, id), nil)
 	if nil != err {
 		return nil, err
 	}
@@ -96,8 +180,8 @@ func (client *SchemaRegistryClient) GetSchema(id int) (*goavro.Codec, error) {
 }
 
 // GetSubjects returns a list of all subjects in the schema registry
-func (client *SchemaRegistryClient) GetSubjects() ([]string, error) {
-	resp, err := client.httpCall("GET", subjects, nil)
+func (client *SchemaRegistryClient) GetSubjects(ctx context.Context) ([]string, error) {
+	resp, err := client.httpCall(ctx, "GET", subjects, nil)
 	if nil != err {
 		return []string{}, err
 	}
@@ -107,8 +191,8 @@ func (client *SchemaRegistryClient) GetSubjects() ([]string, error) {
 }
 
 // GetVersions returns a list of the versions of a subject
-func (client *SchemaRegistryClient) GetVersions(subject string) ([]int, error) {
-	resp, err := client.httpCall("GET", fmt.Sprintf(subjectVersions, subject), nil)
+func (client *SchemaRegistryClient) GetVersions(ctx context.Context, subject string) ([]int, error) {
+	resp, err := client.httpCall(ctx, "GET", fmt.Sprintf(subjectVersions, subject), nil)
 	if nil != err {
 		return []int{}, err
 	}
@@ -117,8 +201,8 @@ func (client *SchemaRegistryClient) GetVersions(subject string) ([]int, error) {
 	return result, err
 }
 
-func (client *SchemaRegistryClient) getSchemaByVersionInternal(subject string, version string) (*goavro.Codec, error) {
-	resp, err := client.httpCall("GET", fmt.Sprintf(subjectByVersion, subject, version), nil)
+func (client *SchemaRegistryClient) getSchemaByVersionInternal(ctx context.Context, subject string, version string) (*goavro.Codec, error) {
+	resp, err := client.httpCall(ctx, "GET", fmt.Sprintf(subjectByVersion, subject, version), nil)
 	if nil != err {
 		return nil, err
 	}
@@ -132,24 +216,23 @@ func (client *SchemaRegistryClient) getSchemaByVersionInternal(subject string, v
 }
 
 // GetSchemaByVersion returns a goavro.Codec for the version of the subject
-func (client *SchemaRegistryClient) GetSchemaByVersion(subject string, version int) (*goavro.Codec, error) {
-	return client.getSchemaByVersionInternal(subject, fmt.Sprintf("%d", version))
+func (client *SchemaRegistryClient) GetSchemaByVersion(ctx context.Context, subject string, version int) (*goavro.Codec, error) {
+	return client.getSchemaByVersionInternal(ctx, subject, fmt.Sprintf("%d", version))
 }
 
 // GetLatestSchema returns a goavro.Codec for the latest version of the subject
-func (client *SchemaRegistryClient) GetLatestSchema(subject string) (*goavro.Codec, error) {
-	return client.getSchemaByVersionInternal(subject, latestVersion)
+func (client *SchemaRegistryClient) GetLatestSchema(ctx context.Context, subject string) (*goavro.Codec, error) {
+	return client.getSchemaByVersionInternal(ctx, subject, LatestVersion)
 }
 
 // CreateSubject adds a schema to the subject
-func (client *SchemaRegistryClient) CreateSubject(subject string, codec *goavro.Codec) (int, error) {
+func (client *SchemaRegistryClient) CreateSubject(ctx context.Context, subject string, codec *goavro.Codec) (int, error) {
 	schema := schemaResponse{codec.Schema()}
 	jsonSchema, err := json.Marshal(schema)
 	if err != nil {
 		return 0, err
 	}
-	payload := bytes.NewBuffer(jsonSchema)
-	resp, err := client.httpCall("POST", fmt.Sprintf(subjectVersions, subject), payload)
+	resp, err := client.httpCall(ctx, "POST", fmt.Sprintf(subjectVersions, subject), jsonSchema)
 	if err != nil {
 		return 0, err
 	}
@@ -157,29 +240,96 @@ func (client *SchemaRegistryClient) CreateSubject(subject string, codec *goavro.
 }
 
 // IsSchemaRegistered tests if the schema is registered, if so it returns the unique id of that schema
-func (client *SchemaRegistryClient) IsSchemaRegistered(subject string, codec *goavro.Codec) (int, error) {
+func (client *SchemaRegistryClient) IsSchemaRegistered(ctx context.Context, subject string, codec *goavro.Codec) (int, error) {
 	schema := schemaResponse{codec.Schema()}
 	jsonSchema, err := json.Marshal(schema)
 	if err != nil {
 		return 0, err
 	}
-	payload := bytes.NewBuffer(jsonSchema)
-	resp, err := client.httpCall("POST", fmt.Sprintf(deleteSubject, subject), payload)
+	resp, err := client.httpCall(ctx, "POST", fmt.Sprintf(deleteSubject, subject), jsonSchema)
 	if err != nil {
 		return 0, err
 	}
 	return parseID(resp)
 }
 
+/*
+TestCompatibility tests the schema against the version of the subject, a number or LatestVersion,
+following the compatibility level of the subject
+Returns an Error with the code 40401 if the subject doesn't exist, or 40402 if the version doesn't exist
+*/
+func (client *SchemaRegistryClient) TestCompatibility(ctx context.Context, subject string, codec *goavro.Codec, version string) (*CompatibilityResult, error) {
+	schema := schemaResponse{codec.Schema()}
+	jsonSchema, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.httpCall(ctx, "POST", fmt.Sprintf(compatibility, subject, version), jsonSchema)
+	if err != nil {
+		return nil, err
+	}
+	result := new(CompatibilityResult)
+	err = json.Unmarshal(resp, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetGlobalCompatibilityLevel returns the compatibility level of the subjects without their own level
+func (client *SchemaRegistryClient) GetGlobalCompatibilityLevel(ctx context.Context) (CompatibilityLevel, error) {
+	return client.getCompatibilityLevel(ctx, globalConfig)
+}
+
+// SetGlobalCompatibilityLevel updates the compatibility level of the subjects without their own level
+func (client *SchemaRegistryClient) SetGlobalCompatibilityLevel(ctx context.Context, level CompatibilityLevel) error {
+	return client.setCompatibilityLevel(ctx, globalConfig, level)
+}
+
+/*
+GetCompatibilityLevel returns the compatibility level of the subject
+Returns an Error with the code 40408, or 40401 on older schema registry versions, if the subject doesn't have its own level
+*/
+func (client *SchemaRegistryClient) GetCompatibilityLevel(ctx context.Context, subject string) (CompatibilityLevel, error) {
+	return client.getCompatibilityLevel(ctx, fmt.Sprintf(subjectConfig, subject))
+}
+
+// SetCompatibilityLevel updates the compatibility level of the subject, the subject doesn't need to exist
+func (client *SchemaRegistryClient) SetCompatibilityLevel(ctx context.Context, subject string, level CompatibilityLevel) error {
+	return client.setCompatibilityLevel(ctx, fmt.Sprintf(subjectConfig, subject), level)
+}
+
+func (client *SchemaRegistryClient) getCompatibilityLevel(ctx context.Context, uri string) (CompatibilityLevel, error) {
+	resp, err := client.httpCall(ctx, "GET", uri, nil)
+	if err != nil {
+		return "", err
+	}
+	var config configResponse
+	err = json.Unmarshal(resp, &config)
+	if err != nil {
+		return "", err
+	}
+	return config.CompatibilityLevel, nil
+}
+
+func (client *SchemaRegistryClient) setCompatibilityLevel(ctx context.Context, uri string, level CompatibilityLevel) error {
+	jsonConfig, err := json.Marshal(configResponse{Compatibility: level})
+	if err != nil {
+		return err
+	}
+	_, err = client.httpCall(ctx, "PUT", uri, jsonConfig)
+	return err
+}
+
 // DeleteSubject deletes a subject. It should only be used in development
-func (client *SchemaRegistryClient) DeleteSubject(subject string) error {
-	_, err := client.httpCall("DELETE", fmt.Sprintf(deleteSubject, subject), nil)
+func (client *SchemaRegistryClient) DeleteSubject(ctx context.Context, subject string) error {
+	_, err := client.httpCall(ctx, "DELETE", fmt.Sprintf(deleteSubject, subject), nil)
 	return err
 }
 
 // DeleteVersion deletes a subject. It should only be used in development
-func (client *SchemaRegistryClient) DeleteVersion(subject string, version int) error {
-	_, err := client.httpCall("DELETE", fmt.Sprintf(subjectByVersion, subject, fmt.Sprintf("%d", version)), nil)
+func (client *SchemaRegistryClient) DeleteVersion(ctx context.Context, subject string, version int) error {
+	_, err := client.httpCall(ctx, "DELETE", fmt.Sprintf(subjectByVersion, subject, fmt.Sprintf("%d", version)), nil)
 	return err
 }
 
@@ -195,31 +345,136 @@ func parseID(str []byte) (int, error) {
 	return id.ID, err
 }
 
-func (client *SchemaRegistryClient) httpCall(method, uri string, payload io.Reader) ([]byte, error) {
-	nServers := len(client.SchemaRegistryConnect)
-	offset := rand.Intn(nServers)
+/*
+Sends the request to the servers in turns, starting by a random one, preferring the ones not failed recently
+The failed requests (5XX responses and http errors) are retried after an exponential backoff with jitter,
+sending the payload again on each attempt
+*/
+func (client *SchemaRegistryClient) httpCall(ctx context.Context, method, uri string, payload []byte) ([]byte, error) {
+	servers := client.servers()
 	for i := 0; ; i++ {
-		url := fmt.Sprintf("%s%s", client.SchemaRegistryConnect[(i+offset)%nServers], uri)
-		req, err := http.NewRequest(method, url, payload)
-		if err != nil {
-			return nil, err
+		server := servers[i%len(servers)]
+		resp, err := client.send(ctx, server, method, uri, payload)
+		if err == nil && !retryable(resp) {
+			client.markHealthy(server)
+			defer resp.Body.Close()
+			if !okStatus(resp) {
+				return nil, newError(resp)
+			}
+			return ioutil.ReadAll(resp.Body)
 		}
-		req.Header.Set("Content-Type", contentType)
-		resp, err := client.httpClient.Do(req)
-		if resp != nil {
+		if ctx.Err() != nil {
+			// The request was given up by the caller, it says nothing about the server
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
+		}
+		client.markUnhealthy(server)
+		if i >= client.retries {
+			if err != nil {
+				return nil, err
+			}
 			defer resp.Body.Close()
+			return nil, newError(resp)
 		}
-		if i < client.retries && (err != nil || retryable(resp)) {
-			continue
+		if resp != nil {
+			// The body is read to reuse the connection on the next attempt
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
 		}
-		if err != nil {
-			return nil, err
+		timer := time.NewTimer(client.retryDelay(i))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
 		}
-		if !okStatus(resp) {
-			return nil, newError(resp)
+	}
+}
+
+func (client *SchemaRegistryClient) send(ctx context.Context, server, method, uri string, payload []byte) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, server+uri, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	err = client.authenticate(req)
+	if err != nil {
+		return nil, err
+	}
+	return client.httpClient.Do(req)
+}
+
+// servers returns the servers rotated by a random offset, with the ones on cooldown at the end
+func (client *SchemaRegistryClient) servers() []string {
+	nServers := len(client.SchemaRegistryConnect)
+	offset := rand.Intn(nServers)
+	now := time.Now()
+	healthy := make([]string, 0, nServers)
+	var unhealthy []string
+	client.unhealthyLock.Lock()
+	for i := 0; i < nServers; i++ {
+		server := client.SchemaRegistryConnect[(i+offset)%nServers]
+		if until, found := client.unhealthy[server]; found && now.Before(until) {
+			unhealthy = append(unhealthy, server)
+		} else {
+			healthy = append(healthy, server)
 		}
-		return ioutil.ReadAll(resp.Body)
 	}
+	client.unhealthyLock.Unlock()
+	return append(healthy, unhealthy...)
+}
+
+func (client *SchemaRegistryClient) markUnhealthy(server string) {
+	if client.cooldown <= 0 {
+		return
+	}
+	client.unhealthyLock.Lock()
+	if client.unhealthy == nil {
+		client.unhealthy = make(map[string]time.Time)
+	}
+	client.unhealthy[server] = time.Now().Add(client.cooldown)
+	client.unhealthyLock.Unlock()
+}
+
+func (client *SchemaRegistryClient) markHealthy(server string) {
+	client.unhealthyLock.Lock()
+	delete(client.unhealthy, server)
+	client.unhealthyLock.Unlock()
+}
+
+// retryDelay returns the delay before the retry of the given attempt, between half and the whole exponential backoff
+func (client *SchemaRegistryClient) retryDelay(attempt int) time.Duration {
+	if client.backoff <= 0 {
+		return 0
+	}
+	delay := client.backoff
+	for i := 0; i < attempt && delay < client.maxBackoff; i++ {
+		delay *= 2
+	}
+	if client.maxBackoff > 0 && delay > client.maxBackoff {
+		delay = client.maxBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// authenticate sets the Authorization header of the request with the configured credentials, if any
+func (client *SchemaRegistryClient) authenticate(req *http.Request) error {
+	if client.tokenProvider != nil {
+		token, err := client.tokenProvider(req.Context())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if client.username != "" {
+		req.SetBasicAuth(client.username, client.password)
+	}
+	return nil
 }
 
 func retryable(resp *http.Response) bool {
@@ -240,6 +495,28 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("%d - %s", e.ErrorCode, e.Message)
 }
 
+// IncompatibleSchemaError is returned when a schema is not compatible with the version registered for a subject
+type IncompatibleSchemaError struct {
+	Subject  string
+	Messages []string
+}
+
+func (e *IncompatibleSchemaError) Error() string {
+	if len(e.Messages) == 0 {
+		return fmt.Sprintf("schema incompatible with the subject %s", e.Subject)
+	}
+	return fmt.Sprintf("schema incompatible with the subject %s: %s", e.Subject, strings.Join(e.Messages, "; "))
+}
+
+// Error codes of the schema registry
+const (
+	ErrorCodeSubjectNotFound = 40401
+	ErrorCodeVersionNotFound = 40402
+	ErrorCodeSchemaNotFound  = 40403
+	// returned by the recent schema registry versions when the subject doesn't have its own compatibility level
+	ErrorCodeSubjectLevelCompatibilityNotConfigured = 40408
+)
+
 func newError(resp *http.Response) *Error {
 	err := &Error{}
 	parsingErr := json.NewDecoder(resp.Body).Decode(&err)
@@ -0,0 +1,31 @@
+package kafka
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPCallCancelledDoesNotMarkTheServerUnhealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	client, err := NewSchemaRegistryClientWithOptions([]string{server.URL}, WithRegistryServerCooldown(time.Minute))
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = client.GetSubjects(ctx)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Empty(t, client.unhealthy)
+}
@@ -0,0 +1,29 @@
+package kafka
+
+/*
+Returns the subject of the schema registry where the schema of the messages of the topic is registered
+isKey tells if the schema is the one of the message keys or of the values, recordName is the full name of the record
+*/
+type SubjectNameStrategy func(topic string, isKey bool, recordName string) string
+
+// TopicNameStrategy uses the topic name with the -key or -value suffix, one record type per topic, it is the default
+func TopicNameStrategy(topic string, isKey bool, recordName string) string {
+	return topic + subjectSuffix(isKey)
+}
+
+// RecordNameStrategy uses the record name, so a record type has the same schema on every topic
+func RecordNameStrategy(topic string, isKey bool, recordName string) string {
+	return recordName
+}
+
+// TopicRecordNameStrategy uses the topic and the record names, several record types per topic
+func TopicRecordNameStrategy(topic string, isKey bool, recordName string) string {
+	return topic + "-" + recordName
+}
+
+func subjectSuffix(isKey bool) string {
+	if isKey {
+		return "-key"
+	}
+	return "-value"
+}
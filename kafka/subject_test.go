@@ -0,0 +1,48 @@
+package kafka_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubjectNameStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy kafka.SubjectNameStrategy
+		isKey    bool
+		expected string
+	}{
+		{name: "topic value", strategy: kafka.TopicNameStrategy, expected: "orders-value"},
+		{name: "topic key", strategy: kafka.TopicNameStrategy, isKey: true, expected: "orders-key"},
+		{name: "record value", strategy: kafka.RecordNameStrategy, expected: "order"},
+		{name: "record key", strategy: kafka.RecordNameStrategy, isKey: true, expected: "order"},
+		{name: "topic record value", strategy: kafka.TopicRecordNameStrategy, expected: "orders-order"},
+		{name: "topic record key", strategy: kafka.TopicRecordNameStrategy, isKey: true, expected: "orders-order"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.strategy("orders", test.isKey, "order"))
+		})
+	}
+}
+
+func TestPublisherRegistersTheSchemaOnTheSubjectOfTheStrategy(t *testing.T) {
+	publisher, server := newPublisher(t, kafka.WithSubjectNameStrategy(kafka.TopicRecordNameStrategy))
+	defer closePublisher(t, publisher)
+	server.ResetRequests()
+	// The unreachable broker never delivers the message, so the publication ends with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	results, err := queuesgo.PublishBatch(ctx, publisher, []*queuesgo.Event{newEvent("1")})
+
+	require.NoError(t, err)
+	assert.Equal(t, context.DeadlineExceeded, results[0].Err)
+	assert.Equal(t, []string{"POST /subjects/orders-order/versions"}, server.Requests())
+}
@@ -0,0 +1,283 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	ckafka "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/linkedin/goavro/v2"
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/internal/router"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const pollTimeoutMs = 100
+
+// defaultRedeliveryPolicy spaces the redeliveries of a message when the subscriber doesn't have a retry policy
+var defaultRedeliveryPolicy = queuesgo.DefaultRetryPolicy
+
+type subscriber struct {
+	config               *ckafka.ConfigMap
+	schemaRegistryClient *CachedSchemaRegistryClient
+	topic                string
+	router               *router.Router
+	schemas              sync.Map // parsed avro schemas by id
+	redeliveryPolicy     queuesgo.RetryPolicy
+	redeliveries         map[topicPartition]int // consecutive redeliveries by topic and partition, only used by the Subscribe goroutine
+}
+
+// decodeError is a message that cannot be decoded however many times it is read
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+/*
+Creates a new Kafka subscriber implementation
+the kafkaServerHosts string can receive several hosts separated by ','
+the subscriber joins the consumer group groupID and reads the messages of the given topic,
+the messages must be encoded with the Confluent wire format used by the Kafka publisher
+the objectType interface should be any of the following types, any other type will cause an error returning a nil value
+1. Copy of a structure
+2. Non-nil pointer to a struct of the expected type.
+Deprecated: use NewSubscriberWithOptions, which returns the cause of the failure
+*/
+func NewSubscriber(kafkaServerHosts, schemaServerAddress, groupID, topic string, objectType interface{}, logMode bool) queuesgo.Subscriber {
+	s, err := NewSubscriberWithOptions(kafkaServerHosts, schemaServerAddress, groupID, topic, objectType, WithLogMode(logMode))
+	if err != nil {
+		return nil
+	}
+	return s
+}
+
+/*
+Creates a new Kafka subscriber implementation
+the kafkaServerHosts string can receive several hosts separated by ','
+the schemaServerAddress string can receive several schema registry addresses separated by ','
+the subscriber joins the consumer group groupID and reads the messages of the given topic,
+the messages must be encoded with the Confluent wire format used by the Kafka publisher
+the objectType interface should be any of the following types, any other type will return an error
+1. Copy of a structure
+2. Non-nil pointer to a struct of the expected type.
+The consumer is created when Subscribe is called, the producer option is ignored
+The subscriber is a queuesgo.RoutingSubscriber and a queuesgo.MiddlewareSubscriber
+*/
+func NewSubscriberWithOptions(kafkaServerHosts, schemaServerAddress, groupID, topic string, objectType interface{}, opts ...Option) (queuesgo.Subscriber, error) {
+	if !queuesgo.ValidateType(objectType) {
+		return nil, errors.New("invalid object type")
+	}
+	o := newOptions(opts)
+	r, err := router.New(reflect.TypeOf(objectType), o.routerConfig(groupID))
+	if err != nil {
+		return nil, err
+	}
+	schemaRegistryClient, err := o.cachedSchemaRegistryClient(schemaServerAddress)
+	if err != nil {
+		return nil, err
+	}
+	redeliveryPolicy := defaultRedeliveryPolicy
+	if o.retryPolicy != nil && o.retryPolicy.InitialDelay > 0 {
+		redeliveryPolicy = *o.retryPolicy
+	}
+	return &subscriber{
+		config: o.configMap(ckafka.ConfigMap{"auto.offset.reset": "earliest"}, ckafka.ConfigMap{
+			"bootstrap.servers":  kafkaServerHosts,
+			"group.id":           groupID,
+			"enable.auto.commit": false,
+		}),
+		schemaRegistryClient: schemaRegistryClient,
+		topic:                topic,
+		router:               r,
+		redeliveryPolicy:     redeliveryPolicy,
+		redeliveries:         make(map[topicPartition]int),
+	}, nil
+}
+
+func (s *subscriber) RegisterFunction(eventName string, handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterFunction(eventName, handler)
+}
+
+func (s *subscriber) RegisterTypedFunction(eventName string, payloadType interface{}, handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterTypedFunction(eventName, payloadType, handler)
+}
+
+func (s *subscriber) RegisterMatchFunction(match queuesgo.EventMatcher, handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterMatchFunction(match, handler)
+}
+
+func (s *subscriber) RegisterFallback(handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterFallback(handler)
+}
+
+func (s *subscriber) Use(middlewares ...queuesgo.Middleware) {
+	s.router.Use(middlewares...)
+}
+
+/*
+Blocks until the context is done or the consumer receives a fatal error
+The offset of a message is committed only when the handler acknowledges it, otherwise the consumer
+seeks back to the message so it is delivered again, waiting between the deliveries the delay of the retry policy,
+or of the DefaultRetryPolicy without one. The same happens when the schema registry fails, while the messages
+that cannot be decoded are logged and committed
+*/
+func (s *subscriber) Subscribe(ctx context.Context) error {
+	consumer, err := ckafka.NewConsumer(s.config)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+	err = consumer.SubscribeTopics([]string{s.topic}, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+		switch e := consumer.Poll(pollTimeoutMs).(type) {
+		case *ckafka.Message:
+			err = s.receive(ctx, consumer, e)
+			if err != nil {
+				return err
+			}
+		case ckafka.Error:
+			if e.IsFatal() {
+				return e
+			}
+			s.router.Logger().Warn("Kafka consumer error", "topic", s.topic, "error", e)
+		}
+	}
+}
+
+func (s *subscriber) receive(ctx context.Context, consumer *ckafka.Consumer, message *ckafka.Message) error {
+	event, err := s.kafkaToEvent(ctx, message)
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		// A message that cannot be decoded will never be, it is committed to avoid blocking the partition
+		s.router.Logger().Error("An error decoding the message", "partition", message.TopicPartition.String(), "error", err)
+		return s.commit(consumer, message)
+	}
+	if err != nil {
+		// The schema registry may be back later, the message is read again
+		s.router.Logger().Warn("An error getting the schema of the message", "partition", message.TopicPartition.String(), "error", err)
+		return s.redeliver(ctx, consumer, message)
+	}
+	s.router.Logger().Debug("Received message", append(queuesgo.EventFields(event),
+		"partition", message.TopicPartition.String())...)
+	ack := s.router.Manager(ctx, event)
+	if ack {
+		return s.commit(consumer, message)
+	}
+	return s.redeliver(ctx, consumer, message)
+}
+
+// topicPartition identifies a partition among the ones of every topic read by the consumer
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+func partitionOf(message *ckafka.Message) topicPartition {
+	var topic string
+	if message.TopicPartition.Topic != nil {
+		topic = *message.TopicPartition.Topic
+	}
+	return topicPartition{topic: topic, partition: message.TopicPartition.Partition}
+}
+
+func (s *subscriber) commit(consumer *ckafka.Consumer, message *ckafka.Message) error {
+	delete(s.redeliveries, partitionOf(message))
+	_, err := consumer.CommitMessage(message)
+	return err
+}
+
+/*
+Seeks back to the message after the delay of the consecutive redeliveries of its partition,
+without seeking when the context is done while waiting as the consumer is closed
+*/
+func (s *subscriber) redeliver(ctx context.Context, consumer *ckafka.Consumer, message *ckafka.Message) error {
+	partition := partitionOf(message)
+	s.redeliveries[partition]++
+	timer := time.NewTimer(s.redeliveryPolicy.Delay(s.redeliveries[partition]))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil
+	}
+	return consumer.Seek(message.TopicPartition, 0)
+}
+
+func (s *subscriber) kafkaToEvent(ctx context.Context, message *ckafka.Message) (queuesgo.Event, error) {
+	avroDecoder := &AvroEncoder{}
+	err := avroDecoder.Decode(message.Value)
+	if err != nil {
+		return queuesgo.Event{}, &decodeError{err}
+	}
+	avroCodec, err := s.schemaRegistryClient.GetSchema(ctx, avroDecoder.SchemaID)
+	var registryErr *Error
+	if errors.As(err, &registryErr) && registryErr.ErrorCode == ErrorCodeSchemaNotFound {
+		return queuesgo.Event{}, &decodeError{err}
+	}
+	if err != nil {
+		return queuesgo.Event{}, err
+	}
+	native, _, err := avroCodec.NativeFromBinary(avroDecoder.Content)
+	if err != nil {
+		return queuesgo.Event{}, &decodeError{err}
+	}
+	schema, err := s.avroSchema(avroDecoder.SchemaID, avroCodec)
+	if err != nil {
+		return queuesgo.Event{}, &decodeError{err}
+	}
+	value, err := schema.fromNative(native)
+	if err != nil {
+		return queuesgo.Event{}, &decodeError{err}
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return queuesgo.Event{}, &decodeError{err}
+	}
+
+	headers := make(map[string]string, len(message.Headers))
+	for _, header := range message.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	metadata := queuesgo.NewEventMetadata(headers)
+	// The event name picks the payload type when there are typed handlers
+	payload := s.router.NewPayload(metadata)
+	err = json.Unmarshal(data, payload)
+	if err != nil {
+		return queuesgo.Event{}, &decodeError{err}
+	}
+
+	event := queuesgo.Event{
+		Payload:  payload,
+		Metadata: metadata,
+	}
+	return event, nil
+}
+
+// avroSchema returns the parsed schema with the given id, used to convert the native values to JSON
+func (s *subscriber) avroSchema(schemaID int, avroCodec *goavro.Codec) (*avroSchema, error) {
+	if schema, ok := s.schemas.Load(schemaID); ok {
+		return schema.(*avroSchema), nil
+	}
+	schema, err := parseAvroSchema(avroCodec.Schema())
+	if err != nil {
+		return nil, err
+	}
+	s.schemas.Store(schemaID, schema)
+	return schema, nil
+}
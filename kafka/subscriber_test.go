@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	ckafka "github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type subscribedOrder struct {
+	ID string `json:"id"`
+}
+
+func TestSubscriberConfig(t *testing.T) {
+	s, err := NewSubscriberWithOptions("localhost:9092", "http://localhost:8081", "group", "orders", subscribedOrder{})
+	require.NoError(t, err)
+	config := *s.(*subscriber).config
+	assert.Equal(t, "earliest", config["auto.offset.reset"])
+
+	s, err = NewSubscriberWithOptions("localhost:9092", "http://localhost:8081", "group", "orders", subscribedOrder{},
+		WithConfig(ckafka.ConfigMap{"auto.offset.reset": "latest", "enable.auto.commit": true, "group.id": "other"}))
+	require.NoError(t, err)
+	config = *s.(*subscriber).config
+	assert.Equal(t, "latest", config["auto.offset.reset"])
+	// the subscriber commits the messages once handled
+	assert.Equal(t, false, config["enable.auto.commit"])
+	assert.Equal(t, "group", config["group.id"])
+	assert.Equal(t, "localhost:9092", config["bootstrap.servers"])
+}
+
+func TestRedeliveriesByTopicAndPartition(t *testing.T) {
+	s, err := NewSubscriberWithOptions("localhost:9092", "http://localhost:8081", "group", "orders", subscribedOrder{})
+	require.NoError(t, err)
+	sub := s.(*subscriber)
+	// The context is done, so the subscriber doesn't seek the consumer
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	message := func(topic string, partition int32) *ckafka.Message {
+		return &ckafka.Message{TopicPartition: ckafka.TopicPartition{Topic: &topic, Partition: partition}}
+	}
+
+	require.NoError(t, sub.redeliver(ctx, nil, message("orders", 0)))
+	require.NoError(t, sub.redeliver(ctx, nil, message("orders", 0)))
+	require.NoError(t, sub.redeliver(ctx, nil, message("refunds", 0)))
+
+	assert.Equal(t, map[topicPartition]int{{"orders", 0}: 2, {"refunds", 0}: 1}, sub.redeliveries)
+}
@@ -0,0 +1,172 @@
+package queuesgo
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+/*
+Structured logger used by the publishers and subscribers
+The keysAndValues are pairs of a string key and any value, as the fields of the log line
+A *slog.Logger of the standard library satisfies this interface
+*/
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// Level of a log line, the loggers discard the lines below their level
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// Returns the fields identifying the event on the log lines: event_name, correlation_id and object_id
+func EventFields(event Event) []interface{} {
+	return []interface{}{
+		"event_name", event.Metadata.EventName,
+		"correlation_id", event.Metadata.CorrelationID,
+		"object_id", event.Metadata.ObjectID,
+	}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	level  Level
+}
+
+/*
+Creates a Logger writing to the standard library logger the lines from the given level
+The lines are formatted as level=info msg="message" key=value
+*/
+func NewStdLogger(logger *log.Logger, level Level) Logger {
+	return &stdLogger{logger: logger, level: level}
+}
+
+func (l *stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(LevelDebug, msg, keysAndValues)
+}
+
+func (l *stdLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(LevelInfo, msg, keysAndValues)
+}
+
+func (l *stdLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(LevelWarn, msg, keysAndValues)
+}
+
+func (l *stdLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(LevelError, msg, keysAndValues)
+}
+
+func (l *stdLogger) log(level Level, msg string, keysAndValues []interface{}) {
+	if level < l.level {
+		return
+	}
+	var line strings.Builder
+	fmt.Fprintf(&line, "level=%s msg=%q", level, msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 == len(keysAndValues) {
+			fmt.Fprintf(&line, " %s=", key)
+			break
+		}
+		switch value := keysAndValues[i+1].(type) {
+		case string:
+			fmt.Fprintf(&line, " %s=%q", key, value)
+		case error:
+			fmt.Fprintf(&line, " %s=%q", key, value.Error())
+		default:
+			fmt.Fprintf(&line, " %s=%v", key, value)
+		}
+	}
+	l.logger.Println(line.String())
+}
+
+// Returns a Logger writing to the standard error the lines from the given level
+func NewDefaultLogger(level Level) Logger {
+	return NewStdLogger(log.New(os.Stderr, "", log.LstdFlags), level)
+}
+
+/*
+Methods of a zap SugaredLogger used by the zap adapter
+*/
+type ZapSugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+type zapLogger struct {
+	logger ZapSugaredLogger
+}
+
+// Creates a Logger writing to a zap SugaredLogger, or any logger with the same methods
+func NewZapLogger(logger ZapSugaredLogger) Logger {
+	return &zapLogger{logger: logger}
+}
+
+func (l *zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debugw(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Infow(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warnw(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Errorw(msg, keysAndValues...)
+}
+
+/*
+Methods of a log/slog Logger used by the slog adapter
+*/
+type SlogLogger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// Creates a Logger writing to a log/slog Logger, or any logger with the same methods
+func NewSlogLogger(logger SlogLogger) Logger {
+	return logger
+}
+
+type nopLogger struct{}
+
+// NopLogger returns a Logger discarding every line
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
@@ -0,0 +1,37 @@
+//go:build go1.21
+
+package queuesgo
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var out bytes.Buffer
+	handler := slog.NewTextHandler(&out, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+	logger := NewSlogLogger(slog.New(handler))
+
+	logger.Debug("discarded", "key", "value")
+	logger.Info("Event handled", "event_name", "order.created", "attempt", 2)
+	logger.Warn("Event handled", "ack", false)
+	logger.Error("Odd keys", "event_name", "order.created", "dangling")
+
+	assert.Equal(t, []string{
+		`level=INFO msg="Event handled" event_name=order.created attempt=2`,
+		`level=WARN msg="Event handled" ack=false`,
+		`level=ERROR msg="Odd keys" event_name=order.created !BADKEY=dangling`,
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
@@ -0,0 +1,95 @@
+package queuesgo
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := NewStdLogger(log.New(&out, "", 0), LevelInfo)
+
+	logger.Debug("discarded", "key", "value")
+	logger.Info("Event handled", "event_name", "order.created", "attempt", 2, "ack", true)
+	logger.Warn("Event handled", "error", errors.New("no handler"))
+	logger.Error("Odd keys", "event_name", "order.created", "dangling")
+
+	assert.Equal(t, []string{
+		`level=info msg="Event handled" event_name="order.created" attempt=2 ack=true`,
+		`level=warn msg="Event handled" error="no handler"`,
+		`level=error msg="Odd keys" event_name="order.created" dangling=`,
+	}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
+
+func TestStdLoggerLevels(t *testing.T) {
+	tests := []struct {
+		level    Level
+		expected []string
+	}{
+		{level: LevelDebug, expected: []string{"debug", "info", "warn", "error"}},
+		{level: LevelInfo, expected: []string{"info", "warn", "error"}},
+		{level: LevelWarn, expected: []string{"warn", "error"}},
+		{level: LevelError, expected: []string{"error"}},
+	}
+	for _, test := range tests {
+		t.Run(test.level.String(), func(t *testing.T) {
+			var out bytes.Buffer
+			logger := NewStdLogger(log.New(&out, "", 0), test.level)
+			logger.Debug("line")
+			logger.Info("line")
+			logger.Warn("line")
+			logger.Error("line")
+
+			var levels []string
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				levels = append(levels, strings.TrimPrefix(strings.Fields(line)[0], "level="))
+			}
+			assert.Equal(t, test.expected, levels)
+		})
+	}
+}
+
+// sugaredLogger records the calls with the methods of a zap SugaredLogger
+type sugaredLogger struct {
+	calls []string
+	args  [][]interface{}
+}
+
+func (l *sugaredLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	l.record("debug "+msg, keysAndValues)
+}
+
+func (l *sugaredLogger) Infow(msg string, keysAndValues ...interface{}) {
+	l.record("info "+msg, keysAndValues)
+}
+
+func (l *sugaredLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	l.record("warn "+msg, keysAndValues)
+}
+
+func (l *sugaredLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	l.record("error "+msg, keysAndValues)
+}
+
+func (l *sugaredLogger) record(call string, keysAndValues []interface{}) {
+	l.calls = append(l.calls, call)
+	l.args = append(l.args, keysAndValues)
+}
+
+func TestZapLogger(t *testing.T) {
+	sugared := &sugaredLogger{}
+	logger := NewZapLogger(sugared)
+
+	logger.Debug("first", "attempt", 1)
+	logger.Info("second", "ack", true)
+	logger.Warn("third")
+	logger.Error("fourth", "event_name", "order.created", "dangling")
+
+	assert.Equal(t, []string{"debug first", "info second", "warn third", "error fourth"}, sugared.calls)
+	assert.Equal(t, [][]interface{}{{"attempt", 1}, {"ack", true}, nil, {"event_name", "order.created", "dangling"}}, sugared.args)
+}
@@ -0,0 +1,191 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Broker is an in-process queue provider with named topics and subscriptions, intended for tests
+// Every message published on a topic is delivered to each of the subscriptions attached to it
+type Broker struct {
+	mu            sync.Mutex
+	topics        map[string][]*subscription
+	subscriptions map[string]*subscription
+	messageID     int64
+	pending       int
+	idle          chan struct{}
+}
+
+type message struct {
+	id         string
+	attributes map[string]string
+	data       []byte
+}
+
+// delivery is a message queued on a subscription, counting the times it has been delivered by the subscription
+type delivery struct {
+	message    *message
+	deliveries int
+}
+
+type subscription struct {
+	broker *Broker
+	name   string
+	queue  []*delivery
+	notify chan struct{}
+}
+
+// NewBroker creates an empty broker without topics nor subscriptions
+func NewBroker() *Broker {
+	idle := make(chan struct{})
+	close(idle)
+	return &Broker{
+		topics:        make(map[string][]*subscription),
+		subscriptions: make(map[string]*subscription),
+		idle:          idle,
+	}
+}
+
+// CreateTopic registers a new topic, returns an error if it already exists
+func (b *Broker) CreateTopic(topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.topics[topic]; ok {
+		return errors.New("topic already exists")
+	}
+	b.topics[topic] = nil
+	return nil
+}
+
+// CreateSubscription attaches a new subscription to an existing topic
+// Only the messages published after the creation are delivered to the subscription
+func (b *Broker) CreateSubscription(topic, subscriptionName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscriptions, ok := b.topics[topic]
+	if !ok {
+		return errors.New("topic doesn't exist")
+	}
+	if _, ok := b.subscriptions[subscriptionName]; ok {
+		return errors.New("subscription already exists")
+	}
+	sub := &subscription{
+		broker: b,
+		name:   subscriptionName,
+		notify: make(chan struct{}, 1),
+	}
+	b.topics[topic] = append(subscriptions, sub)
+	b.subscriptions[subscriptionName] = sub
+	return nil
+}
+
+/*
+Blocks until every message published on the broker has been acknowledged by the subscribers
+Returns the context error if it is done before, which is the case when a handler keeps rejecting an event
+and the subscriber doesn't have a maximum of deliveries
+*/
+func (b *Broker) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	idle := b.idle
+	b.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Broker) hasTopic(topic string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.topics[topic]
+	return ok
+}
+
+func (b *Broker) subscription(subscriptionName string) *subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscriptions[subscriptionName]
+}
+
+func (b *Broker) publish(topic string, msg *message) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscriptions, ok := b.topics[topic]
+	if !ok {
+		return "", errors.New("topic doesn't exist")
+	}
+	b.messageID++
+	msg.id = strconv.FormatInt(b.messageID, 10)
+	for _, sub := range subscriptions {
+		b.addPending()
+		sub.enqueue(&delivery{message: msg})
+	}
+	return msg.id, nil
+}
+
+// addPending must be called holding the broker lock
+func (b *Broker) addPending() {
+	if b.pending == 0 {
+		b.idle = make(chan struct{})
+	}
+	b.pending++
+}
+
+func (b *Broker) ack() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending--
+	if b.pending == 0 {
+		close(b.idle)
+	}
+}
+
+// nack queues again the message on the subscription after the delay, it is still pending while waiting
+func (b *Broker) nack(sub *subscription, d *delivery, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		sub.enqueue(d)
+	})
+}
+
+// enqueue must be called holding the broker lock
+func (s *subscription) enqueue(d *delivery) {
+	s.queue = append(s.queue, d)
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next blocks until a message is available on the subscription or the context is done
+func (s *subscription) next(ctx context.Context) (*delivery, bool) {
+	for {
+		s.broker.mu.Lock()
+		if len(s.queue) > 0 {
+			d := s.queue[0]
+			d.deliveries++
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			if len(s.queue) > 0 {
+				select {
+				case s.notify <- struct{}{}:
+				default:
+				}
+			}
+			s.broker.mu.Unlock()
+			return d, true
+		}
+		s.broker.mu.Unlock()
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package memory
+
+import (
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/internal/router"
+)
+
+// Option configures the construction of a publisher or a subscriber
+type Option func(*options)
+
+type options struct {
+	logger             queuesgo.Logger
+	retryPolicy        *queuesgo.RetryPolicy
+	deadLetter         queuesgo.Publisher
+	unknownEventPolicy queuesgo.UnknownEventPolicy
+	maxDeliveries      int
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.logger == nil {
+		o.logger = queuesgo.NewDefaultLogger(queuesgo.LevelInfo)
+	}
+	return o
+}
+
+// routerConfig returns the options handled by the router of the subscriber reading from the subscription
+func (o *options) routerConfig(subscription string) router.Config {
+	config := router.Config{
+		Logger:             o.logger,
+		RetryPolicy:        o.retryPolicy,
+		UnknownEventPolicy: o.unknownEventPolicy,
+	}
+	if o.deadLetter != nil {
+		config.DeadLetter = &queuesgo.DeadLetterQueue{Publisher: o.deadLetter, Subscription: subscription}
+	}
+	return config
+}
+
+// WithLogger writes the logs to the given logger instead of the standard error
+func WithLogger(logger queuesgo.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+/*
+Calls again the handler of an event not acknowledged, following the given policy, before leaving it to the queue provider
+The handlers are retried in process, so the message is held by the subscriber while waiting
+The delay of the policy also spaces the redeliveries of the messages left to the broker
+*/
+func WithRetryPolicy(policy queuesgo.RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = &policy
+	}
+}
+
+/*
+Republishes through the given publisher the events not acknowledged once the retries are exhausted,
+acknowledging the original message. The events carry the dead letter attributes on the extra metadata
+A publisher created with a map[string]interface{} type takes the payloads of every typed handler
+*/
+func WithDeadLetter(publisher queuesgo.Publisher) Option {
+	return func(o *options) {
+		o.deadLetter = publisher
+	}
+}
+
+/*
+Sets what the subscriber does with the events without a registered handler nor a fallback, acknowledging them by default
+DeadLetterUnknownEvents requires WithDeadLetter, the subscriber cannot be created otherwise
+*/
+func WithUnknownEventPolicy(policy queuesgo.UnknownEventPolicy) Option {
+	return func(o *options) {
+		o.unknownEventPolicy = policy
+	}
+}
+
+/*
+Drops the messages not acknowledged after being delivered the given times, logging them as errors,
+so Broker.Wait returns when a handler keeps rejecting an event. 0, the default, redelivers them forever
+*/
+func WithMaxDeliveries(deliveries int) Option {
+	return func(o *options) {
+		o.maxDeliveries = deliveries
+	}
+}
@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/internal/inflight"
+	"reflect"
+)
+
+type publisher struct {
+	broker       *Broker
+	topic        string
+	objectType   reflect.Type
+	publications inflight.Publications
+}
+
+/*
+Creates a new in-memory publisher sending the events to the given topic of the broker
+The topic must already exist in the broker.
+the objectType interface should be any of the following types, any other type will return an error
+1. Copy of a structure
+2. Non-nil pointer to a struct of the expected type.
+3. A map with key string and any value
+The publisher is a queuesgo.ClosablePublisher, a queuesgo.BatchPublisher and a queuesgo.TypedPublisher
+*/
+func NewPublisher(broker *Broker, topic string, objectType interface{}) (queuesgo.Publisher, error) {
+	if !queuesgo.ValidateType(objectType) {
+		return nil, errors.New("invalid object type")
+	}
+	if !broker.hasTopic(topic) {
+		return nil, errors.New("topic doesn't exist")
+	}
+	return &publisher{
+		broker:     broker,
+		topic:      topic,
+		objectType: reflect.TypeOf(objectType),
+	}, nil
+}
+
+func (p *publisher) AcceptsPayload(payload interface{}) bool {
+	return queuesgo.ValidateRegisteredType(payload, p.objectType)
+}
+
+func (p *publisher) PublishSync(ctx context.Context, event *queuesgo.Event) (string, error) {
+	msg, err := p.eventToMessage(event)
+	if err != nil {
+		return "", err
+	}
+	if err = p.publications.Add(1); err != nil {
+		return "", err
+	}
+	id, err := p.broker.publish(p.topic, msg)
+	p.publications.Done(err)
+	queuesgo.RecordMessageSize(ctx, len(msg.data))
+	return id, err
+}
+
+func (p *publisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
+	msg, err := p.eventToMessage(event)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.publications.Add(1); err != nil {
+		return nil, err
+	}
+	res := make(chan queuesgo.PublicationResult, 1)
+	id, err := p.broker.publish(p.topic, msg)
+	p.publications.Done(err)
+	queuesgo.RecordMessageSize(ctx, len(msg.data))
+	res <- queuesgo.PublicationResult{Result: id, Err: err}
+	close(res)
+	return res, nil
+}
+
+// PublishBatch validates every event before delivering them to the broker, in order
+func (p *publisher) PublishBatch(ctx context.Context, events []*queuesgo.Event) ([]queuesgo.PublicationResult, error) {
+	messages := make([]*message, len(events))
+	for i, event := range events {
+		msg, err := p.eventToMessage(event)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		messages[i] = msg
+	}
+	if err := p.publications.Add(len(messages)); err != nil {
+		return nil, err
+	}
+	results := make([]queuesgo.PublicationResult, len(messages))
+	for i, msg := range messages {
+		id, err := p.broker.publish(p.topic, msg)
+		p.publications.Done(err)
+		queuesgo.RecordMessageSize(ctx, len(msg.data))
+		results[i] = queuesgo.PublicationResult{Result: id, Err: err}
+	}
+	return results, nil
+}
+
+/*
+Rejects the next publications, the events are delivered to the broker as they are published,
+so there is nothing to flush
+*/
+func (p *publisher) Close(ctx context.Context) error {
+	if err := p.publications.Close(); err != nil {
+		return err
+	}
+	if errs := p.publications.Wait(ctx); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (p *publisher) eventToMessage(event *queuesgo.Event) (*message, error) {
+	if !queuesgo.ValidateRegisteredType(event.Payload, p.objectType) {
+		return nil, errors.New("invalid payload")
+	}
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return nil, errors.New("invalid payload")
+	}
+	if event.Metadata.IsZero() {
+		return nil, errors.New("invalid metadata")
+	}
+	return &message{
+		attributes: event.Metadata.Attributes(),
+		data:       data,
+	}, nil
+}
@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/internal/router"
+	"reflect"
+)
+
+// defaultRedeliveryPolicy spaces the redeliveries of a message when the subscriber doesn't have a retry policy
+var defaultRedeliveryPolicy = queuesgo.DefaultRetryPolicy
+
+type subscriber struct {
+	broker           *Broker
+	subscriptionName string
+	router           *router.Router
+	redeliveryPolicy queuesgo.RetryPolicy
+	maxDeliveries    int
+}
+
+/*
+Creates a new in-memory subscriber reading from the given subscription of the broker
+The subscription must already exist in the broker.
+the objectType interface should be any of the following types, any other type will return an error
+1. Copy of a structure
+2. Non-nil pointer to a struct of the expected type.
+3. A map with key string and any value
+The subscriber is a queuesgo.RoutingSubscriber and a queuesgo.MiddlewareSubscriber
+*/
+func NewSubscriber(broker *Broker, subscriptionName string, objectType interface{}, opts ...Option) (queuesgo.Subscriber, error) {
+	if !queuesgo.ValidateType(objectType) {
+		return nil, errors.New("invalid object type")
+	}
+	if broker.subscription(subscriptionName) == nil {
+		return nil, errors.New("subscription doesn't exist")
+	}
+	o := newOptions(opts)
+	r, err := router.New(reflect.TypeOf(objectType), o.routerConfig(subscriptionName))
+	if err != nil {
+		return nil, err
+	}
+	redeliveryPolicy := defaultRedeliveryPolicy
+	if o.retryPolicy != nil && o.retryPolicy.InitialDelay > 0 {
+		redeliveryPolicy = *o.retryPolicy
+	}
+	return &subscriber{
+		broker:           broker,
+		subscriptionName: subscriptionName,
+		router:           r,
+		redeliveryPolicy: redeliveryPolicy,
+		maxDeliveries:    o.maxDeliveries,
+	}, nil
+}
+
+func (s *subscriber) RegisterFunction(eventName string, handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterFunction(eventName, handler)
+}
+
+func (s *subscriber) RegisterTypedFunction(eventName string, payloadType interface{}, handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterTypedFunction(eventName, payloadType, handler)
+}
+
+func (s *subscriber) RegisterMatchFunction(match queuesgo.EventMatcher, handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterMatchFunction(match, handler)
+}
+
+func (s *subscriber) RegisterFallback(handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterFallback(handler)
+}
+
+func (s *subscriber) Use(middlewares ...queuesgo.Middleware) {
+	s.router.Use(middlewares...)
+}
+
+/*
+Blocks handling the events of the subscription until the context is done
+Events not acknowledged by the handler are queued again on the subscription after the delay of the retry policy,
+or of the DefaultRetryPolicy without one, growing with the deliveries of the message
+*/
+func (s *subscriber) Subscribe(ctx context.Context) error {
+	sub := s.broker.subscription(s.subscriptionName)
+	for {
+		d, ok := sub.next(ctx)
+		if !ok {
+			return nil
+		}
+		msg := d.message
+		event, err := s.messageToEvent(msg)
+		if err != nil {
+			s.router.Logger().Error("An error decoding the message", "message_id", msg.id, "subscription", s.subscriptionName, "error", err)
+			s.broker.ack()
+			continue
+		}
+		switch {
+		case s.router.Manager(ctx, event):
+			s.broker.ack()
+		case s.maxDeliveries > 0 && d.deliveries >= s.maxDeliveries:
+			s.router.Logger().Error("Dropping the message after the maximum deliveries", append(queuesgo.EventFields(event),
+				"message_id", msg.id, "subscription", s.subscriptionName, "deliveries", d.deliveries)...)
+			s.broker.ack()
+		default:
+			s.broker.nack(sub, d, s.redeliveryPolicy.Delay(d.deliveries))
+		}
+	}
+}
+
+func (s *subscriber) messageToEvent(msg *message) (queuesgo.Event, error) {
+	metadata := queuesgo.NewEventMetadata(msg.attributes)
+	payload := s.router.NewPayload(metadata)
+	err := json.Unmarshal(msg.data, payload)
+	if err != nil {
+		return queuesgo.Event{}, err
+	}
+
+	event := queuesgo.Event{
+		Payload:  payload,
+		Metadata: metadata,
+	}
+	return event, nil
+}
@@ -0,0 +1,303 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+func newEvent(objectID string, payload interface{}) *queuesgo.Event {
+	return &queuesgo.Event{
+		Payload: payload,
+		Metadata: queuesgo.EventMetadata{
+			UserID:        "user",
+			CorrelationID: "correlation-" + objectID,
+			EventName:     "order_created",
+			Origin:        "test",
+			Timestamp:     time.Now().UnixNano() / int64(time.Millisecond),
+			ObjectID:      objectID,
+		},
+	}
+}
+
+// newTopic creates a broker with the topic and its subscriptions
+func newTopic(t *testing.T, topic string, subscriptions ...string) *Broker {
+	t.Helper()
+	broker := NewBroker()
+	require.NoError(t, broker.CreateTopic(topic))
+	for _, subscription := range subscriptions {
+		require.NoError(t, broker.CreateSubscription(topic, subscription))
+	}
+	return broker
+}
+
+// subscribe runs the subscriber until the test ends
+func subscribe(t *testing.T, subscriber queuesgo.Subscriber) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- subscriber.Subscribe(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+}
+
+// waitIdle waits until every message published on the broker is acknowledged
+func waitIdle(t *testing.T, broker *Broker) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, broker.Wait(ctx))
+}
+
+// counter records the deliveries of the events by object id
+type counter struct {
+	mu         sync.Mutex
+	deliveries map[string][]time.Time
+	payloads   []*order
+}
+
+func (c *counter) handler(acknowledgeAfter int) queuesgo.HandlerFunc {
+	return func(ctx context.Context, event queuesgo.Event) (bool, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.deliveries == nil {
+			c.deliveries = make(map[string][]time.Time)
+		}
+		id := event.Metadata.ObjectID
+		c.deliveries[id] = append(c.deliveries[id], time.Now())
+		c.payloads = append(c.payloads, event.Payload.(*order))
+		return acknowledgeAfter > 0 && len(c.deliveries[id]) >= acknowledgeAfter, nil
+	}
+}
+
+func (c *counter) times(objectID string) []time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deliveries[objectID]
+}
+
+func TestPublishAndSubscribe(t *testing.T) {
+	broker := newTopic(t, "orders", "billing", "shipping")
+	publisher, err := NewPublisher(broker, "orders", order{})
+	require.NoError(t, err)
+	var billing, shipping counter
+	for name, c := range map[string]*counter{"billing": &billing, "shipping": &shipping} {
+		subscriber, err := NewSubscriber(broker, name, order{})
+		require.NoError(t, err)
+		require.NoError(t, subscriber.RegisterFunction("order_created", c.handler(1)))
+		subscribe(t, subscriber)
+	}
+
+	id, err := publisher.PublishSync(context.Background(), newEvent("1", order{ID: "1", Total: 10}))
+	require.NoError(t, err)
+	assert.NotEmpty(t, id)
+	waitIdle(t, broker)
+
+	for _, c := range []*counter{&billing, &shipping} {
+		assert.Len(t, c.times("1"), 1)
+		assert.Equal(t, []*order{{ID: "1", Total: 10}}, c.payloads)
+	}
+}
+
+func TestPublishInvalidEvents(t *testing.T) {
+	broker := newTopic(t, "orders")
+	publisher, err := NewPublisher(broker, "orders", order{})
+	require.NoError(t, err)
+
+	_, err = publisher.PublishSync(context.Background(), newEvent("1", "not an order"))
+	assert.EqualError(t, err, "invalid payload")
+	event := newEvent("1", order{})
+	event.Metadata.EventName = ""
+	_, err = publisher.PublishSync(context.Background(), event)
+	assert.EqualError(t, err, "invalid metadata")
+}
+
+func TestNackedMessagesAreRedeliveredAfterTheDelay(t *testing.T) {
+	broker := newTopic(t, "orders", "billing")
+	publisher, err := NewPublisher(broker, "orders", order{})
+	require.NoError(t, err)
+	delay := 50 * time.Millisecond
+	subscriber, err := NewSubscriber(broker, "billing", order{}, WithRetryPolicy(queuesgo.RetryPolicy{InitialDelay: delay}))
+	require.NoError(t, err)
+	var c counter
+	require.NoError(t, subscriber.RegisterFunction("order_created", c.handler(3)))
+	subscribe(t, subscriber)
+
+	_, err = publisher.PublishSync(context.Background(), newEvent("1", order{ID: "1"}))
+	require.NoError(t, err)
+	waitIdle(t, broker)
+
+	deliveries := c.times("1")
+	require.Len(t, deliveries, 3)
+	for i := 1; i < len(deliveries); i++ {
+		assert.GreaterOrEqual(t, int64(deliveries[i].Sub(deliveries[i-1])), int64(delay))
+	}
+}
+
+func TestRedeliveryDoesNotBlockOtherMessages(t *testing.T) {
+	broker := newTopic(t, "orders", "billing")
+	publisher, err := NewPublisher(broker, "orders", order{})
+	require.NoError(t, err)
+	subscriber, err := NewSubscriber(broker, "billing", order{}, WithRetryPolicy(queuesgo.RetryPolicy{InitialDelay: time.Hour}))
+	require.NoError(t, err)
+	acknowledged := make(chan string, 1)
+	require.NoError(t, subscriber.RegisterFunction("order_created", func(ctx context.Context, event queuesgo.Event) (bool, error) {
+		if event.Metadata.ObjectID == "rejected" {
+			return false, nil
+		}
+		acknowledged <- event.Metadata.ObjectID
+		return true, nil
+	}))
+	subscribe(t, subscriber)
+
+	_, err = publisher.PublishSync(context.Background(), newEvent("rejected", order{}))
+	require.NoError(t, err)
+	_, err = publisher.PublishSync(context.Background(), newEvent("accepted", order{}))
+	require.NoError(t, err)
+
+	select {
+	case id := <-acknowledged:
+		assert.Equal(t, "accepted", id)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second message was not delivered")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, broker.Wait(ctx), "the rejected message is still pending")
+}
+
+func TestMaxDeliveriesDropsTheMessage(t *testing.T) {
+	broker := newTopic(t, "orders", "billing")
+	publisher, err := NewPublisher(broker, "orders", order{})
+	require.NoError(t, err)
+	subscriber, err := NewSubscriber(broker, "billing", order{},
+		WithRetryPolicy(queuesgo.RetryPolicy{InitialDelay: time.Millisecond}), WithMaxDeliveries(4))
+	require.NoError(t, err)
+	var c counter
+	require.NoError(t, subscriber.RegisterFunction("order_created", c.handler(0)))
+	subscribe(t, subscriber)
+
+	_, err = publisher.PublishSync(context.Background(), newEvent("1", order{ID: "1"}))
+	require.NoError(t, err)
+	waitIdle(t, broker)
+
+	assert.Len(t, c.times("1"), 4)
+}
+
+func TestUndecodableMessagesAreAcknowledged(t *testing.T) {
+	broker := newTopic(t, "orders", "billing")
+	publisher, err := NewPublisher(broker, "orders", map[string]interface{}{})
+	require.NoError(t, err)
+	subscriber, err := NewSubscriber(broker, "billing", order{})
+	require.NoError(t, err)
+	var c counter
+	require.NoError(t, subscriber.RegisterFunction("order_created", c.handler(1)))
+	subscribe(t, subscriber)
+
+	_, err = publisher.PublishSync(context.Background(), newEvent("1", map[string]interface{}{"total": "ten"}))
+	require.NoError(t, err)
+	waitIdle(t, broker)
+
+	assert.Empty(t, c.times("1"))
+}
+
+func TestPublishBatchAndClose(t *testing.T) {
+	broker := newTopic(t, "orders", "billing")
+	publisher, err := NewPublisher(broker, "orders", order{})
+	require.NoError(t, err)
+	subscriber, err := NewSubscriber(broker, "billing", order{})
+	require.NoError(t, err)
+	var c counter
+	require.NoError(t, subscriber.RegisterFunction("order_created", c.handler(1)))
+	subscribe(t, subscriber)
+
+	_, err = queuesgo.PublishBatch(context.Background(), publisher, []*queuesgo.Event{
+		newEvent("1", order{ID: "1"}),
+		newEvent("2", order{ID: "2"}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, publisher.(queuesgo.ClosablePublisher).Close(context.Background()))
+	waitIdle(t, broker)
+
+	assert.Len(t, c.times("1"), 1)
+	assert.Len(t, c.times("2"), 1)
+	_, err = publisher.PublishSync(context.Background(), newEvent("3", order{ID: "3"}))
+	assert.Error(t, err)
+}
+
+type refund struct {
+	OrderID string `json:"order_id"`
+	Amount  int    `json:"amount"`
+}
+
+func TestDeadLetterWithTypedHandlers(t *testing.T) {
+	broker := newTopic(t, "orders", "billing")
+	require.NoError(t, broker.CreateTopic("dead_letters"))
+	require.NoError(t, broker.CreateSubscription("dead_letters", "inspection"))
+	publisher, err := NewPublisher(broker, "orders", map[string]interface{}{})
+	require.NoError(t, err)
+	deadLetter, err := NewPublisher(broker, "dead_letters", map[string]interface{}{})
+	require.NoError(t, err)
+	subscriber, err := NewSubscriber(broker, "billing", order{}, WithDeadLetter(deadLetter))
+	require.NoError(t, err)
+	reject := func(ctx context.Context, event queuesgo.Event) (bool, error) { return false, nil }
+	require.NoError(t, subscriber.RegisterFunction("order_created", reject))
+	require.NoError(t, queuesgo.RegisterTypedFunction(subscriber, "refund_created", refund{}, reject))
+	subscribe(t, subscriber)
+	received := make(chan queuesgo.Event, 2)
+	inspection, err := NewSubscriber(broker, "inspection", map[string]interface{}{})
+	require.NoError(t, err)
+	require.NoError(t, inspection.RegisterFunction("*", func(ctx context.Context, event queuesgo.Event) (bool, error) {
+		received <- event
+		return true, nil
+	}))
+	subscribe(t, inspection)
+
+	_, err = publisher.PublishSync(context.Background(), newEvent("1", map[string]interface{}{"id": "1", "total": 10}))
+	require.NoError(t, err)
+	refundEvent := newEvent("2", map[string]interface{}{"order_id": "1", "amount": 5})
+	refundEvent.Metadata.EventName = "refund_created"
+	_, err = publisher.PublishSync(context.Background(), refundEvent)
+	require.NoError(t, err)
+	waitIdle(t, broker)
+
+	payloads := make(map[string]interface{})
+	for i := 0; i < 2; i++ {
+		event := <-received
+		assert.Equal(t, queuesgo.ReasonNotAcknowledged, event.Metadata.Extra[queuesgo.DeadLetterReason])
+		payloads[event.Metadata.EventName] = event.Payload
+	}
+	assert.Equal(t, map[string]interface{}{
+		"order_created":  &map[string]interface{}{"id": "1", "total": float64(10)},
+		"refund_created": &map[string]interface{}{"order_id": "1", "amount": float64(5)},
+	}, payloads)
+}
+
+func TestDeadLetterRejectsTypedHandlersItCannotPublish(t *testing.T) {
+	broker := newTopic(t, "orders", "billing", "dead_letters")
+	deadLetter, err := NewPublisher(broker, "orders", order{})
+	require.NoError(t, err)
+	subscriber, err := NewSubscriber(broker, "billing", order{}, WithDeadLetter(deadLetter))
+	require.NoError(t, err)
+	handler := func(ctx context.Context, event queuesgo.Event) (bool, error) { return true, nil }
+
+	assert.NoError(t, queuesgo.RegisterTypedFunction(subscriber, "order_updated", &order{}, handler))
+	assert.EqualError(t, queuesgo.RegisterTypedFunction(subscriber, "refund_created", refund{}, handler),
+		"the dead letter publisher doesn't accept the payload type memory.refund")
+	_, err = NewSubscriber(broker, "billing", refund{}, WithDeadLetter(deadLetter))
+	assert.Error(t, err)
+}
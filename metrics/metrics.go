@@ -0,0 +1,150 @@
+// Package metrics records Prometheus metrics of the publishers and subscribers through their middlewares
+package metrics
+
+import (
+	"context"
+	"errors"
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Outcomes of the handled events
+const (
+	OutcomeAck          = "ack"
+	OutcomeNack         = "nack"
+	OutcomeError        = "error"
+	OutcomeUnregistered = "unregistered"
+)
+
+// Results of the publications
+const (
+	ResultSuccess = "success"
+	ResultError   = "error"
+)
+
+// Metrics holds the collectors shared by the middlewares
+type Metrics struct {
+	published        *prometheus.CounterVec
+	publishDuration  *prometheus.HistogramVec
+	messageSize      *prometheus.HistogramVec
+	handled          *prometheus.CounterVec
+	handlerDuration  *prometheus.HistogramVec
+	handlersInFlight *prometheus.GaugeVec
+}
+
+/*
+Creates the collectors with the given namespace and registers them on the registerer
+Returns an error if they are already registered
+*/
+func New(namespace string, registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "published_events_total",
+			Help:      "Events published by topic and result.",
+		}, []string{"topic", "result"}),
+		publishDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "publish_duration_seconds",
+			Help:      "Time until the queue provider confirms the publication, by topic and result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"topic", "result"}),
+		messageSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "published_message_bytes",
+			Help:      "Size of the messages sent to the queue provider, encoded in its wire format, by topic.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"topic"}),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "handled_events_total",
+			Help:      "Events received by subscription, event name and outcome.",
+		}, []string{"subscription", "event_name", "outcome"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "handler_duration_seconds",
+			Help:      "Time spent handling an event, by subscription and event name.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"subscription", "event_name"}),
+		handlersInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "handlers_in_flight",
+			Help:      "Events being handled, by subscription.",
+		}, []string{"subscription"}),
+	}
+	collectors := []prometheus.Collector{m.published, m.publishDuration, m.messageSize, m.handled, m.handlerDuration, m.handlersInFlight}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+/*
+Records the publications of the topic, for both PublishSync and PublishAsync
+The duration goes from the call until the result is available
+The message size is the one reported by the publisher through RecordMessageSize, as the publishers of this module do,
+so it is not recorded for other publishers
+*/
+func (m *Metrics) PublisherMiddleware(topic string) queuesgo.PublisherMiddleware {
+	return func(next queuesgo.PublishFunc) queuesgo.PublishFunc {
+		return func(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
+			start := time.Now()
+			ctx = queuesgo.WithMessageSizeRecorder(ctx, func(size int) {
+				m.messageSize.WithLabelValues(topic).Observe(float64(size))
+			})
+			res, err := next(ctx, event)
+			if err != nil {
+				m.observePublication(topic, start, err)
+				return nil, err
+			}
+			return queuesgo.OnPublicationResult(res, func(result queuesgo.PublicationResult) {
+				m.observePublication(topic, start, result.Err)
+			}), nil
+		}
+	}
+}
+
+/*
+Records the events handled on the subscription
+When the subscriber has a retry policy every attempt is recorded
+*/
+func (m *Metrics) Middleware(subscription string) queuesgo.Middleware {
+	return func(next queuesgo.HandlerFunc) queuesgo.HandlerFunc {
+		return func(ctx context.Context, event queuesgo.Event) (bool, error) {
+			eventName := event.Metadata.EventName
+			inFlight := m.handlersInFlight.WithLabelValues(subscription)
+			inFlight.Inc()
+			defer inFlight.Dec()
+			start := time.Now()
+			ack, err := next(ctx, event)
+			m.handlerDuration.WithLabelValues(subscription, eventName).Observe(time.Since(start).Seconds())
+			m.handled.WithLabelValues(subscription, eventName, outcome(ack, err)).Inc()
+			return ack, err
+		}
+	}
+}
+
+func (m *Metrics) observePublication(topic string, start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	m.published.WithLabelValues(topic, result).Inc()
+	m.publishDuration.WithLabelValues(topic, result).Observe(time.Since(start).Seconds())
+}
+
+func outcome(ack bool, err error) string {
+	switch {
+	case errors.Is(err, queuesgo.ErrUnregisteredEvent):
+		return OutcomeUnregistered
+	case err != nil:
+		return OutcomeError
+	case ack:
+		return OutcomeAck
+	default:
+		return OutcomeNack
+	}
+}
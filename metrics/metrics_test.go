@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/memory"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type order struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+func newEvent(objectID string) *queuesgo.Event {
+	return &queuesgo.Event{
+		Payload: order{ID: objectID, Total: 10},
+		Metadata: queuesgo.EventMetadata{
+			CorrelationID: "correlation-" + objectID,
+			EventName:     "order_created",
+			Origin:        "test",
+			Timestamp:     1600000000000,
+			ObjectID:      objectID,
+		},
+	}
+}
+
+// histogram returns the count and the sum of the histogram with the given name
+func histogram(t *testing.T, registry *prometheus.Registry, name string) (uint64, float64) {
+	t.Helper()
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			require.Len(t, family.GetMetric(), 1)
+			h := family.GetMetric()[0].GetHistogram()
+			return h.GetSampleCount(), h.GetSampleSum()
+		}
+	}
+	return 0, 0
+}
+
+func TestPublisherMiddlewareRecordsTheMessageSize(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := New("test", registry)
+	require.NoError(t, err)
+	broker := memory.NewBroker()
+	require.NoError(t, broker.CreateTopic("orders"))
+	inner, err := memory.NewPublisher(broker, "orders", order{})
+	require.NoError(t, err)
+	publisher := queuesgo.WrapPublisher(inner, m.PublisherMiddleware("orders"))
+	data, err := json.Marshal(order{ID: "1", Total: 10})
+	require.NoError(t, err)
+
+	_, err = publisher.PublishSync(context.Background(), newEvent("1"))
+	require.NoError(t, err)
+	res, err := publisher.PublishAsync(context.Background(), newEvent("1"))
+	require.NoError(t, err)
+	require.NoError(t, (<-res).Err)
+
+	count, sum := histogram(t, registry, "test_published_message_bytes")
+	assert.Equal(t, uint64(2), count)
+	assert.Equal(t, float64(2*len(data)), sum)
+}
+
+func TestPublisherMiddlewareWithoutSizeReports(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := New("test", registry)
+	require.NoError(t, err)
+	publish := m.PublisherMiddleware("orders")(func(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
+		res := make(chan queuesgo.PublicationResult, 1)
+		res <- queuesgo.PublicationResult{Result: "sent"}
+		close(res)
+		return res, nil
+	})
+
+	res, err := publish(context.Background(), newEvent("1"))
+	require.NoError(t, err)
+	assert.Equal(t, "sent", (<-res).Result)
+
+	count, _ := histogram(t, registry, "test_published_message_bytes")
+	assert.Equal(t, uint64(0), count)
+}
@@ -0,0 +1,122 @@
+package queuesgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+Function wrapping a HandlerFunc to add behaviour before and after the handling of an event
+The subscribers apply the registered middlewares around the handler of every received event
+*/
+type Middleware func(HandlerFunc) HandlerFunc
+
+var (
+	// ErrHandlerTimeout is returned by the Timeout middleware when the handler doesn't finish on time
+	ErrHandlerTimeout = errors.New("handler timeout")
+	// ErrUnregisteredEvent is seen by the middlewares for events without a registered handler, see UnknownEventPolicy
+	ErrUnregisteredEvent = errors.New("no function registered for the event")
+)
+
+/*
+Wraps the handler with the given middlewares
+The first middleware is the outermost one, so it is the first called when an event is received
+*/
+func Chain(handler HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+/*
+Recovers from a panic on the handler, returning it as an error
+The event is not acknowledged so the queue provider can resend it
+*/
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event Event) (ack bool, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					ack = false
+					err = fmt.Errorf("panic handling event %s: %v", event.Metadata.EventName, r)
+				}
+			}()
+			return next(ctx, event)
+		}
+	}
+}
+
+/*
+Limits the time the handler has to process an event, the handler context is cancelled after the timeout
+If the handler doesn't return on time the event is not acknowledged and ErrHandlerTimeout is returned,
+the handler keeps running on background until it returns
+Inside the Retry middleware, the next attempt waits for the handler to return before calling it again
+*/
+func Timeout(timeout time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event Event) (bool, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			type result struct {
+				ack   bool
+				err   error
+				panic interface{}
+			}
+			done := make(chan result, 1)
+			finished := make(chan struct{})
+			go func() {
+				var res result
+				defer func() {
+					res.panic = recover()
+					done <- res
+					close(finished)
+				}()
+				res.ack, res.err = next(ctx, event)
+			}()
+			select {
+			case res := <-done:
+				if res.panic != nil {
+					// Panics are raised on the caller go routine so the Recovery middleware can handle them
+					panic(res.panic)
+				}
+				return res.ack, res.err
+			case <-ctx.Done():
+				if running, ok := ctx.Value(runningKey{}).(*<-chan struct{}); ok {
+					*running = finished
+				}
+				return false, ErrHandlerTimeout
+			}
+		}
+	}
+}
+
+/*
+Logs a line for every handled event with the fields
+event_name, correlation_id, object_id, origin, attempt, ack, duration and error if any
+The events acknowledged without error are logged on info level, the others on warn level
+*/
+func Logging(logger Logger) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event Event) (bool, error) {
+			start := time.Now()
+			ack, err := next(ctx, event)
+			fields := append(EventFields(event),
+				"origin", event.Metadata.Origin,
+				"attempt", Attempt(ctx),
+				"ack", ack,
+				"duration", time.Since(start))
+			if err != nil || !ack {
+				if err != nil {
+					fields = append(fields, "error", err)
+				}
+				logger.Warn("Event handled", fields...)
+			} else {
+				logger.Info("Event handled", fields...)
+			}
+			return ack, err
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package queuesgo
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type logLine struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+// recordingLogger keeps the lines logged, with their fields by key
+type recordingLogger struct {
+	lines []logLine
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(LevelDebug, msg, keysAndValues)
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(LevelInfo, msg, keysAndValues)
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(LevelWarn, msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(LevelError, msg, keysAndValues)
+}
+
+func (l *recordingLogger) log(level Level, msg string, keysAndValues []interface{}) {
+	fields := map[string]interface{}{}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+	l.lines = append(l.lines, logLine{level: level, msg: msg, fields: fields})
+}
+
+var handledEvent = Event{Metadata: EventMetadata{EventName: "order.created", ObjectID: "1", Origin: "shop"}}
+
+func TestRecovery(t *testing.T) {
+	logger := &recordingLogger{}
+	handler := Chain(func(ctx context.Context, event Event) (bool, error) {
+		panic("boom")
+	}, Logging(logger), Recovery())
+
+	ack, err := handler(context.Background(), handledEvent)
+
+	assert.False(t, ack)
+	assert.EqualError(t, err, "panic handling event order.created: boom")
+	require.Len(t, logger.lines, 1)
+	assert.Equal(t, LevelWarn, logger.lines[0].level)
+	assert.Equal(t, err, logger.lines[0].fields["error"])
+}
+
+func TestTimeout(t *testing.T) {
+	cancelled := make(chan error, 1)
+	handler := Timeout(20 * time.Millisecond)(func(ctx context.Context, event Event) (bool, error) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return true, nil
+	})
+
+	ack, err := handler(context.Background(), handledEvent)
+
+	assert.False(t, ack)
+	assert.Equal(t, ErrHandlerTimeout, err)
+	select {
+	case err := <-cancelled:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(time.Second):
+		t.Fatal("the handler context was not cancelled")
+	}
+}
+
+func TestTimeoutReturnsTheHandlerResult(t *testing.T) {
+	handler := Timeout(time.Second)(func(ctx context.Context, event Event) (bool, error) {
+		return false, errors.New("unavailable")
+	})
+
+	ack, err := handler(context.Background(), handledEvent)
+
+	assert.False(t, ack)
+	assert.EqualError(t, err, "unavailable")
+}
+
+func TestTimeoutInsideRetryDoesNotRunTheHandlerTwice(t *testing.T) {
+	var running, overlaps, calls int32
+	handler := Chain(func(ctx context.Context, event Event) (bool, error) {
+		if atomic.AddInt32(&running, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		defer atomic.AddInt32(&running, -1)
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// the first attempt ignores its context, running well after the timeout
+			time.Sleep(100 * time.Millisecond)
+		}
+		return true, nil
+	}, Retry(RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond}), Timeout(10*time.Millisecond))
+
+	ack, err := handler(context.Background(), handledEvent)
+
+	assert.True(t, ack)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Zero(t, atomic.LoadInt32(&overlaps))
+}
+
+func TestLogging(t *testing.T) {
+	logger := &recordingLogger{}
+	results := []error{nil, errors.New("unavailable")}
+	handler := Logging(logger)(func(ctx context.Context, event Event) (bool, error) {
+		err := results[0]
+		results = results[1:]
+		return err == nil, err
+	})
+
+	_, _ = handler(context.Background(), handledEvent)
+	_, _ = handler(context.Background(), handledEvent)
+
+	require.Len(t, logger.lines, 2)
+	for _, line := range logger.lines {
+		assert.Equal(t, "Event handled", line.msg)
+		assert.Equal(t, "order.created", line.fields["event_name"])
+		assert.Equal(t, "1", line.fields["object_id"])
+		assert.Equal(t, "shop", line.fields["origin"])
+		assert.Equal(t, 1, line.fields["attempt"])
+		assert.Contains(t, line.fields, "duration")
+	}
+	assert.Equal(t, LevelInfo, logger.lines[0].level)
+	assert.Equal(t, true, logger.lines[0].fields["ack"])
+	assert.NotContains(t, logger.lines[0].fields, "error")
+	assert.Equal(t, LevelWarn, logger.lines[1].level)
+	assert.Equal(t, false, logger.lines[1].fields["ack"])
+	assert.EqualError(t, logger.lines[1].fields["error"].(error), "unavailable")
+}
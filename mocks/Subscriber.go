@@ -11,6 +11,20 @@ type Subscriber struct {
 	mock.Mock
 }
 
+// RegisterFallback provides a mock function with given fields: handler
+func (_m *Subscriber) RegisterFallback(handler queuesgo.HandlerFunc) error {
+	ret := _m.Called(handler)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(queuesgo.HandlerFunc) error); ok {
+		r0 = rf(handler)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // RegisterFunction provides a mock function with given fields: eventName, handler
 func (_m *Subscriber) RegisterFunction(eventName string, handler queuesgo.HandlerFunc) error {
 	ret := _m.Called(eventName, handler)
@@ -25,6 +39,34 @@ func (_m *Subscriber) RegisterFunction(eventName string, handler queuesgo.Handle
 	return r0
 }
 
+// RegisterMatchFunction provides a mock function with given fields: match, handler
+func (_m *Subscriber) RegisterMatchFunction(match queuesgo.EventMatcher, handler queuesgo.HandlerFunc) error {
+	ret := _m.Called(match, handler)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(queuesgo.EventMatcher, queuesgo.HandlerFunc) error); ok {
+		r0 = rf(match, handler)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RegisterTypedFunction provides a mock function with given fields: eventName, payloadType, handler
+func (_m *Subscriber) RegisterTypedFunction(eventName string, payloadType interface{}, handler queuesgo.HandlerFunc) error {
+	ret := _m.Called(eventName, payloadType, handler)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, interface{}, queuesgo.HandlerFunc) error); ok {
+		r0 = rf(eventName, payloadType, handler)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Subscribe provides a mock function with given fields: ctx
 func (_m *Subscriber) Subscribe(ctx context.Context) error {
 	ret := _m.Called(ctx)
@@ -38,3 +80,14 @@ func (_m *Subscriber) Subscribe(ctx context.Context) error {
 
 	return r0
 }
+
+// Use provides a mock function with given fields: middlewares
+func (_m *Subscriber) Use(middlewares ...queuesgo.Middleware) {
+	_va := make([]interface{}, len(middlewares))
+	for _i := range middlewares {
+		_va[_i] = middlewares[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _va...)
+	_m.Called(_ca...)
+}
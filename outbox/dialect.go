@@ -0,0 +1,109 @@
+// Package outbox stores events in a database table inside the caller transaction, relaying them later to a publisher
+package outbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect adapts the statements of the outbox to a database engine
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	Postgres
+	MySQL
+)
+
+/*
+Returns the statement creating the outbox table with the given name
+Columns:
+id: sequential identifier, keeps the order of the events
+topic: topic of the publisher that stored the event
+event: the event serialized as JSON
+created_at: moment the event was stored (epoch millis)
+sent_at: moment the relay published the event (epoch millis), null while pending
+attempts: times the relay failed to publish the event
+last_error: error of the last failed attempt
+failed_at: moment the relay gave up on the event (epoch millis), null while pending
+*/
+func (d Dialect) CreateTable(table string) string {
+	var id, text string
+	switch d {
+	case Postgres:
+		id, text = "BIGSERIAL PRIMARY KEY", "TEXT"
+	case MySQL:
+		id, text = "BIGINT AUTO_INCREMENT PRIMARY KEY", "LONGTEXT"
+	default:
+		id, text = "INTEGER PRIMARY KEY AUTOINCREMENT", "TEXT"
+	}
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id %s,
+	topic VARCHAR(255) NOT NULL,
+	event %s NOT NULL,
+	created_at BIGINT NOT NULL,
+	sent_at BIGINT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error %s NULL,
+	failed_at BIGINT NULL
+)`, table, id, text, text)
+}
+
+// CreateIndex returns the statement creating the index used by the relay to find the pending events of a topic
+func (d Dialect) CreateIndex(table string) string {
+	if d == MySQL {
+		return fmt.Sprintf("CREATE INDEX %s_pending ON %s (topic, sent_at, failed_at, id)", table, table)
+	}
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_pending ON %s (topic, sent_at, failed_at, id)", table, table)
+}
+
+// DropTable returns the statement removing the outbox table
+func (d Dialect) DropTable(table string) string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s", table)
+}
+
+func (d Dialect) insert(table string) string {
+	return fmt.Sprintf("INSERT INTO %s (topic, event, created_at) VALUES (%s)", table, d.placeholders(1, 3))
+}
+
+func (d Dialect) selectPending(table string) string {
+	statement := fmt.Sprintf("SELECT id, event, attempts FROM %s WHERE topic = %s AND sent_at IS NULL AND failed_at IS NULL ORDER BY id LIMIT %s",
+		table, d.placeholders(1, 1), d.placeholders(2, 1))
+	if d != SQLite {
+		// Keeps other relays from sending the same events concurrently
+		statement += " FOR UPDATE"
+	}
+	return statement
+}
+
+func (d Dialect) markSent(table string) string {
+	return fmt.Sprintf("UPDATE %s SET sent_at = %s WHERE id = %s", table, d.placeholders(1, 1), d.placeholders(2, 1))
+}
+
+func (d Dialect) markAttempt(table string) string {
+	return fmt.Sprintf("UPDATE %s SET attempts = %s, last_error = %s, failed_at = %s WHERE id = %s",
+		table, d.placeholders(1, 1), d.placeholders(2, 1), d.placeholders(3, 1), d.placeholders(4, 1))
+}
+
+func (d Dialect) retryFailed(table string) string {
+	return fmt.Sprintf("UPDATE %s SET attempts = 0, failed_at = NULL WHERE topic = %s AND failed_at IS NOT NULL",
+		table, d.placeholders(1, 1))
+}
+
+func (d Dialect) deleteSent(table string) string {
+	return fmt.Sprintf("DELETE FROM %s WHERE sent_at IS NOT NULL AND sent_at < %s", table, d.placeholders(1, 1))
+}
+
+// placeholders returns n comma separated bind parameters starting on the position from
+func (d Dialect) placeholders(from, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		if d == Postgres {
+			params[i] = "$" + strconv.Itoa(from+i)
+		} else {
+			params[i] = "?"
+		}
+	}
+	return strings.Join(params, ", ")
+}
@@ -0,0 +1,327 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+const table = "outbox"
+
+type order struct {
+	ID    string `json:"id"`
+	Total int    `json:"total"`
+}
+
+// recordingPublisher keeps the events published, failing the ones rejected by fail
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []*queuesgo.Event
+	fail   func(event *queuesgo.Event) error
+}
+
+func (p *recordingPublisher) PublishSync(ctx context.Context, event *queuesgo.Event) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail != nil {
+		if err := p.fail(event); err != nil {
+			return "", err
+		}
+	}
+	p.events = append(p.events, event)
+	return "published", nil
+}
+
+func (p *recordingPublisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
+	result, err := p.PublishSync(ctx, event)
+	res := make(chan queuesgo.PublicationResult, 1)
+	res <- queuesgo.PublicationResult{Result: result, Err: err}
+	close(res)
+	return res, nil
+}
+
+func (p *recordingPublisher) objectIDs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, len(p.events))
+	for i, event := range p.events {
+		ids[i] = event.Metadata.ObjectID
+	}
+	return ids
+}
+
+func newEvent(objectID string) *queuesgo.Event {
+	return &queuesgo.Event{
+		Payload: order{ID: objectID, Total: 10},
+		Metadata: queuesgo.EventMetadata{
+			CorrelationID: "correlation-" + objectID,
+			EventName:     "order_created",
+			Origin:        "test",
+			Timestamp:     1600000000000,
+			ObjectID:      objectID,
+		},
+	}
+}
+
+// openDB opens a new SQLite database with the outbox table
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "outbox.db"))
+	require.NoError(t, err)
+	// SQLite allows a single writer
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	_, err = db.Exec(SQLite.CreateTable(table))
+	require.NoError(t, err)
+	_, err = db.Exec(SQLite.CreateIndex(table))
+	require.NoError(t, err)
+	return db
+}
+
+// store writes the events of the topic on the outbox in a single transaction
+func store(t *testing.T, db *sql.DB, topic string, objectIDs ...string) {
+	t.Helper()
+	publisher, err := NewPublisher(SQLite, table, topic, order{})
+	require.NoError(t, err)
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	for _, id := range objectIDs {
+		_, err = publisher.Tx(tx).PublishSync(context.Background(), newEvent(id))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+}
+
+func count(t *testing.T, db *sql.DB, condition string) int {
+	t.Helper()
+	var n int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE "+condition).Scan(&n))
+	return n
+}
+
+func TestTableLifecycle(t *testing.T) {
+	db := openDB(t)
+
+	// The statements can run again on an existing table
+	_, err := db.Exec(SQLite.CreateTable(table))
+	assert.NoError(t, err)
+	_, err = db.Exec(SQLite.CreateIndex(table))
+	assert.NoError(t, err)
+	store(t, db, "orders", "1")
+	assert.Equal(t, 1, count(t, db, "sent_at IS NULL"))
+
+	_, err = db.Exec(SQLite.DropTable(table))
+	require.NoError(t, err)
+	_, err = db.Exec("SELECT COUNT(*) FROM " + table)
+	assert.Error(t, err)
+	_, err = db.Exec(SQLite.DropTable(table))
+	assert.NoError(t, err)
+}
+
+func TestPublisherTx(t *testing.T) {
+	db := openDB(t)
+	publisher, err := NewPublisher(SQLite, table, "orders", order{})
+	require.NoError(t, err)
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = publisher.Tx(tx).PublishSync(context.Background(), newEvent("rolled back"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	assert.Equal(t, 0, count(t, db, "1 = 1"))
+
+	tx, err = db.Begin()
+	require.NoError(t, err)
+	res, err := publisher.Tx(tx).PublishAsync(context.Background(), newEvent("committed"))
+	require.NoError(t, err)
+	assert.NoError(t, (<-res).Err)
+	require.NoError(t, tx.Commit())
+	assert.Equal(t, 1, count(t, db, "topic = 'orders' AND sent_at IS NULL"))
+}
+
+func TestPublisherTxInvalidEvents(t *testing.T) {
+	db := openDB(t)
+	publisher, err := NewPublisher(SQLite, table, "orders", order{})
+	require.NoError(t, err)
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	event := newEvent("1")
+	event.Payload = "not an order"
+	_, err = publisher.Tx(tx).PublishSync(context.Background(), event)
+	assert.EqualError(t, err, "invalid payload")
+	event = newEvent("1")
+	event.Metadata.Origin = ""
+	_, err = publisher.Tx(tx).PublishSync(context.Background(), event)
+	assert.EqualError(t, err, "invalid metadata")
+}
+
+func TestRelayProcess(t *testing.T) {
+	db := openDB(t)
+	store(t, db, "orders", "1", "2", "3")
+	store(t, db, "refunds", "4")
+	publisher := &recordingPublisher{}
+	relay, err := NewRelay(db, SQLite, table, "orders", publisher, order{}, WithBatchSize(2))
+	require.NoError(t, err)
+
+	sent, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	sent, err = relay.Process(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	sent, err = relay.Process(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	assert.Equal(t, []string{"1", "2", "3"}, publisher.objectIDs())
+	assert.Equal(t, &order{ID: "1", Total: 10}, publisher.events[0].Payload)
+	assert.Equal(t, newEvent("1").Metadata, publisher.events[0].Metadata)
+	assert.Equal(t, 3, count(t, db, "topic = 'orders' AND sent_at IS NOT NULL"))
+	assert.Equal(t, 1, count(t, db, "topic = 'refunds' AND sent_at IS NULL"))
+}
+
+func TestRelayProcessStopsOnTheFailingEvent(t *testing.T) {
+	db := openDB(t)
+	store(t, db, "orders", "1", "2", "3")
+	failing := true
+	publisher := &recordingPublisher{fail: func(event *queuesgo.Event) error {
+		if failing && event.Metadata.ObjectID == "2" {
+			return errors.New("unavailable")
+		}
+		return nil
+	}}
+	relay, err := NewRelay(db, SQLite, table, "orders", publisher, order{})
+	require.NoError(t, err)
+
+	sent, err := relay.Process(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"1"}, publisher.objectIDs())
+
+	failing = false
+	sent, err = relay.Process(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"1", "2", "3"}, publisher.objectIDs())
+}
+
+func TestRelayDeleteSent(t *testing.T) {
+	db := openDB(t)
+	store(t, db, "orders", "1", "2")
+	relay, err := NewRelay(db, SQLite, table, "orders", &recordingPublisher{}, order{}, WithBatchSize(1))
+	require.NoError(t, err)
+	_, err = relay.Process(context.Background())
+	require.NoError(t, err)
+
+	deleted, err := relay.DeleteSent(context.Background(), time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+	deleted, err = relay.DeleteSent(context.Background(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.Equal(t, 1, count(t, db, "sent_at IS NULL"))
+}
+
+func TestRelayRun(t *testing.T) {
+	db := openDB(t)
+	store(t, db, "orders", "1", "2")
+	publisher := &recordingPublisher{}
+	relay, err := NewRelay(db, SQLite, table, "orders", publisher, order{}, WithPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- relay.Run(ctx)
+	}()
+
+	store(t, db, "orders", "3")
+	assert.Eventually(t, func() bool {
+		return len(publisher.objectIDs()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"1", "2", "3"}, publisher.objectIDs())
+}
+
+func TestRelayMarksTheEventFailedAfterTheMaxAttempts(t *testing.T) {
+	db := openDB(t)
+	store(t, db, "orders", "1", "2")
+	publisher := &recordingPublisher{fail: func(event *queuesgo.Event) error {
+		if event.Metadata.ObjectID == "1" {
+			return errors.New("rejected")
+		}
+		return nil
+	}}
+	relay, err := NewRelay(db, SQLite, table, "orders", publisher, order{}, WithMaxAttempts(3))
+	require.NoError(t, err)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		sent, err := relay.Process(context.Background())
+		assert.EqualError(t, err, "event 1: rejected")
+		assert.Equal(t, 0, sent)
+		assert.Equal(t, 1, count(t, db, fmt.Sprintf("id = 1 AND attempts = %d AND last_error = 'rejected'", attempt)))
+	}
+	assert.Equal(t, 1, count(t, db, "id = 1 AND failed_at IS NOT NULL"))
+
+	sent, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"2"}, publisher.objectIDs())
+
+	// The failed event is not deleted with the sent ones
+	deleted, err := relay.DeleteSent(context.Background(), time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.Equal(t, 1, count(t, db, "id = 1"))
+}
+
+func TestRelayMarksUndecodableEventsFailed(t *testing.T) {
+	db := openDB(t)
+	_, err := db.Exec(SQLite.insert(table), "orders", `{"payload": "not an order"}`, 0)
+	require.NoError(t, err)
+	store(t, db, "orders", "2")
+	publisher := &recordingPublisher{}
+	relay, err := NewRelay(db, SQLite, table, "orders", publisher, order{})
+	require.NoError(t, err)
+
+	_, err = relay.Process(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 1, count(t, db, "id = 1 AND attempts = 1 AND failed_at IS NOT NULL"))
+	sent, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+}
+
+func TestRelayRetryFailed(t *testing.T) {
+	db := openDB(t)
+	store(t, db, "orders", "1")
+	store(t, db, "refunds", "2")
+	_, err := db.Exec("UPDATE " + table + " SET attempts = 5, last_error = 'rejected', failed_at = 1")
+	require.NoError(t, err)
+	publisher := &recordingPublisher{}
+	relay, err := NewRelay(db, SQLite, table, "orders", publisher, order{})
+	require.NoError(t, err)
+
+	retried, err := relay.RetryFailed(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), retried)
+	sent, err := relay.Process(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"1"}, publisher.objectIDs())
+	assert.Equal(t, 1, count(t, db, "topic = 'refunds' AND failed_at IS NOT NULL"))
+}
@@ -0,0 +1,109 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	queuesgo "github.com/merlinapp/queues-go"
+	"reflect"
+	"time"
+)
+
+// Publisher stores the events of a topic on the outbox table
+type Publisher struct {
+	dialect    Dialect
+	table      string
+	topic      string
+	objectType reflect.Type
+}
+
+type txPublisher struct {
+	*Publisher
+	tx *sql.Tx
+}
+
+/*
+Creates a new outbox publisher storing the events of the topic on the given table
+The table must already exist, see Dialect.CreateTable
+the objectType interface should be any of the following types, any other type will return an error
+1. Copy of a structure
+2. Non-nil pointer to a struct of the expected type.
+3. A map with key string and any value
+The publishers returned by Tx are queuesgo.TypedPublisher
+*/
+func NewPublisher(dialect Dialect, table, topic string, objectType interface{}) (*Publisher, error) {
+	if !queuesgo.ValidateType(objectType) {
+		return nil, errors.New("invalid object type")
+	}
+	if table == "" || topic == "" {
+		return nil, errors.New("invalid table or topic")
+	}
+	return &Publisher{
+		dialect:    dialect,
+		table:      table,
+		topic:      topic,
+		objectType: reflect.TypeOf(objectType),
+	}, nil
+}
+
+/*
+Returns a publisher writing the events inside the given transaction
+The events are relayed only if the transaction is committed, both PublishSync and PublishAsync
+return once the event is written on the table
+*/
+func (p *Publisher) Tx(tx *sql.Tx) queuesgo.Publisher {
+	return &txPublisher{Publisher: p, tx: tx}
+}
+
+func (p *Publisher) AcceptsPayload(payload interface{}) bool {
+	return queuesgo.ValidateRegisteredType(payload, p.objectType)
+}
+
+func (p *txPublisher) PublishSync(ctx context.Context, event *queuesgo.Event) (string, error) {
+	data, err := p.eventToRow(event)
+	if err != nil {
+		return "", err
+	}
+	return p.store(ctx, data)
+}
+
+func (p *txPublisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
+	data, err := p.eventToRow(event)
+	if err != nil {
+		return nil, err
+	}
+	res := make(chan queuesgo.PublicationResult, 1)
+	// The transaction cannot be used concurrently, so the row is written before returning
+	result, err := p.store(ctx, data)
+	res <- queuesgo.PublicationResult{Result: result, Err: err}
+	close(res)
+	return res, nil
+}
+
+func (p *txPublisher) store(ctx context.Context, data string) (string, error) {
+	_, err := p.tx.ExecContext(ctx, p.dialect.insert(p.table), p.topic, data, millis(time.Now()))
+	if err != nil {
+		return "", err
+	}
+	queuesgo.RecordMessageSize(ctx, len(data))
+	return "Stored event on outbox " + p.table, nil
+}
+
+func (p *txPublisher) eventToRow(event *queuesgo.Event) (string, error) {
+	if !queuesgo.ValidateRegisteredType(event.Payload, p.objectType) {
+		return "", errors.New("invalid payload")
+	}
+	if event.Metadata.IsZero() {
+		return "", errors.New("invalid metadata")
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return "", errors.New("invalid payload")
+	}
+	return string(data), nil
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,244 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	queuesgo "github.com/merlinapp/queues-go"
+	"reflect"
+	"time"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = time.Second
+	defaultMaxAttempts  = 5
+)
+
+// Relay forwards the pending events of a topic from the outbox table to a publisher
+type Relay struct {
+	db           *sql.DB
+	dialect      Dialect
+	table        string
+	topic        string
+	publisher    queuesgo.Publisher
+	objectType   reflect.Type
+	batchSize    int
+	pollInterval time.Duration
+	maxAttempts  int
+	log          queuesgo.Logger
+}
+
+// RelayOption configures the construction of a relay
+type RelayOption func(*Relay)
+
+// WithBatchSize sets the maximum amount of events read from the table on each poll, 100 by default
+func WithBatchSize(batchSize int) RelayOption {
+	return func(r *Relay) {
+		r.batchSize = batchSize
+	}
+}
+
+// WithPollInterval sets the time waited when there are no pending events, 1 second by default
+func WithPollInterval(pollInterval time.Duration) RelayOption {
+	return func(r *Relay) {
+		r.pollInterval = pollInterval
+	}
+}
+
+/*
+WithMaxAttempts sets the times the relay tries to publish an event before marking it as failed, 5 by default
+The failed events are skipped, keeping the error of the last attempt, until RetryFailed is called
+*/
+func WithMaxAttempts(maxAttempts int) RelayOption {
+	return func(r *Relay) {
+		r.maxAttempts = maxAttempts
+	}
+}
+
+// WithLogger writes the logs to the given logger instead of the standard error
+func WithLogger(logger queuesgo.Logger) RelayOption {
+	return func(r *Relay) {
+		r.log = logger
+	}
+}
+
+/*
+Creates a new relay sending the events stored for the topic to the publisher
+the objectType must be the one used by the outbox publisher of the topic, the events are decoded into it
+*/
+func NewRelay(db *sql.DB, dialect Dialect, table, topic string, publisher queuesgo.Publisher, objectType interface{}, opts ...RelayOption) (*Relay, error) {
+	if !queuesgo.ValidateType(objectType) {
+		return nil, errors.New("invalid object type")
+	}
+	if publisher == nil {
+		return nil, errors.New("invalid publisher")
+	}
+	r := &Relay{
+		db:           db,
+		dialect:      dialect,
+		table:        table,
+		topic:        topic,
+		publisher:    publisher,
+		objectType:   reflect.TypeOf(objectType),
+		batchSize:    defaultBatchSize,
+		pollInterval: defaultPollInterval,
+		maxAttempts:  defaultMaxAttempts,
+		log:          queuesgo.NewDefaultLogger(queuesgo.LevelInfo),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r, nil
+}
+
+/*
+Blocks relaying the pending events until the context is done
+The errors are logged and the relay waits the poll interval before trying again
+*/
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		sent, err := r.Process(ctx)
+		if err != nil {
+			r.log.Error("An error relaying the events", "table", r.table, "topic", r.topic, "sent", sent, "error", err)
+		}
+		if sent < r.batchSize || err != nil {
+			timer := time.NewTimer(r.pollInterval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			}
+		} else if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+/*
+Publishes a batch of pending events in the order they were stored, marking them as sent
+It stops on the first event that cannot be published, recording the attempt and its error, so the next call
+starts again from it, the events are delivered at least once
+The event is marked as failed once it reaches the maximum attempts, or right away if it cannot be decoded,
+so the next call continues with the following events
+Returns the amount of events sent
+*/
+func (r *Relay) Process(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, r.dialect.selectPending(r.table), r.topic, r.batchSize)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		id       int64
+		event    string
+		attempts int
+	}
+	var pending []row
+	for rows.Next() {
+		var rw row
+		if err = rows.Scan(&rw.id, &rw.event, &rw.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, rw)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var publishErr error
+	for _, rw := range pending {
+		event, err := r.rowToEvent(rw.event)
+		undecodable := err != nil
+		if err == nil {
+			_, err = r.publisher.PublishSync(ctx, event)
+		}
+		if err != nil {
+			publishErr = fmt.Errorf("event %d: %s", rw.id, err.Error())
+			// The publications interrupted by the relay shutdown are not attempts
+			if ctx.Err() != nil {
+				break
+			}
+			if markErr := r.markAttempt(ctx, tx, rw.id, rw.attempts+1, undecodable, err); markErr != nil {
+				publishErr = fmt.Errorf("%s, recording the attempt failed: %s", publishErr.Error(), markErr.Error())
+			}
+			break
+		}
+		_, err = tx.ExecContext(ctx, r.dialect.markSent(r.table), millis(time.Now()), rw.id)
+		if err != nil {
+			publishErr = err
+			break
+		}
+		sent++
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return sent, publishErr
+}
+
+// markAttempt records the failed attempt, marking the event as failed on the last one
+func (r *Relay) markAttempt(ctx context.Context, tx *sql.Tx, id int64, attempts int, undecodable bool, attemptErr error) error {
+	var failedAt interface{}
+	if undecodable || attempts >= r.maxAttempts {
+		failedAt = millis(time.Now())
+		r.log.Error("Giving up relaying the event", "table", r.table, "topic", r.topic, "id", id,
+			"attempts", attempts, "error", attemptErr)
+	}
+	_, err := tx.ExecContext(ctx, r.dialect.markAttempt(r.table), attempts, attemptErr.Error(), failedAt, id)
+	return err
+}
+
+// RetryFailed makes the failed events of the topic pending again, returning the amount of events
+func (r *Relay) RetryFailed(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, r.dialect.retryFailed(r.table), r.topic)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteSent removes the events sent before the given moment
+func (r *Relay) DeleteSent(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx, r.dialect.deleteSent(r.table), millis(before))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r *Relay) rowToEvent(data string) (*queuesgo.Event, error) {
+	var stored struct {
+		Payload  json.RawMessage        `json:"payload"`
+		Metadata queuesgo.EventMetadata `json:"metadata"`
+	}
+	err := json.Unmarshal([]byte(data), &stored)
+	if err != nil {
+		return nil, err
+	}
+	var payload interface{}
+	if r.objectType.Kind() == reflect.Ptr {
+		payload = reflect.New(r.objectType.Elem()).Interface()
+	} else {
+		payload = reflect.New(r.objectType).Interface()
+	}
+	err = json.Unmarshal(stored.Payload, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &queuesgo.Event{
+		Payload:  payload,
+		Metadata: stored.Metadata,
+	}, nil
+}
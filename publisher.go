@@ -1,6 +1,11 @@
 package queuesgo
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 type Publisher interface {
 	/*
@@ -27,3 +32,98 @@ type PublicationResult struct {
 	Result string
 	Err    error
 }
+
+/*
+Publisher holding resources (connections, buffered messages) that must be released on shutdown
+*/
+type ClosablePublisher interface {
+	Publisher
+	/*
+		Waits for the outstanding asynchronous publications until the context is done and releases the resources
+		Returns a PublicationErrors with the publications that failed or were not confirmed on time
+		The publications done after closing the publisher return ErrPublisherClosed
+	*/
+	Close(ctx context.Context) error
+}
+
+// ErrPublisherClosed is returned by the publications done after closing the publisher
+var ErrPublisherClosed = errors.New("publisher closed")
+
+/*
+Aggregate error of the publications that did not make it to the queue provider when closing a publisher
+*/
+type PublicationErrors []error
+
+func (e PublicationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d publications failed: %s", len(e), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the aggregated errors so they can be inspected with errors.Is and errors.As
+func (e PublicationErrors) Unwrap() []error {
+	return e
+}
+
+// ClosePublisher closes the publisher if it is a ClosablePublisher, doing nothing otherwise
+func ClosePublisher(ctx context.Context, publisher Publisher) error {
+	if closable, ok := publisher.(ClosablePublisher); ok {
+		return closable.Close(ctx)
+	}
+	return nil
+}
+
+/*
+Publisher telling if it accepts a payload before publishing it, implemented by the publishers of this module
+*/
+type TypedPublisher interface {
+	Publisher
+	// AcceptsPayload returns if the payload has the type registered on the construction of the publisher
+	AcceptsPayload(payload interface{}) bool
+}
+
+/*
+Publisher sending several events at once with the native batching of the queue provider
+*/
+type BatchPublisher interface {
+	Publisher
+	/*
+		Sends the events to the topic waiting for the server confirmation of all of them
+		Every event is validated before sending any of them, returning an error if any of them is incorrect
+		Returns a result per event, in the same order, with the error of the events that couldn't be sent
+	*/
+	PublishBatch(ctx context.Context, events []*Event) ([]PublicationResult, error)
+}
+
+/*
+Publishes the events with PublishBatch if the publisher is a BatchPublisher
+Otherwise every event is sent with PublishAsync and the results are awaited, so the events are not validated
+up front and the errors returned by PublishAsync are set on the result of the event
+*/
+func PublishBatch(ctx context.Context, publisher Publisher, events []*Event) ([]PublicationResult, error) {
+	if batchPublisher, ok := publisher.(BatchPublisher); ok {
+		return batchPublisher.PublishBatch(ctx, events)
+	}
+	return publishEach(ctx, publisher.PublishAsync, events), nil
+}
+
+func publishEach(ctx context.Context, publish PublishFunc, events []*Event) []PublicationResult {
+	pending := make([]<-chan PublicationResult, len(events))
+	results := make([]PublicationResult, len(events))
+	for i, event := range events {
+		res, err := publish(ctx, event)
+		if err != nil {
+			results[i] = PublicationResult{Err: err}
+			continue
+		}
+		pending[i] = res
+	}
+	for i, res := range pending {
+		if res != nil {
+			results[i] = <-res
+		}
+	}
+	return results
+}
@@ -0,0 +1,198 @@
+package queuesgo
+
+import "context"
+
+/*
+Function sending an event to the queue provider
+Returns a channel that will receive the result of the publication, or an error if the event could not be sent
+*/
+type PublishFunc func(ctx context.Context, event *Event) (<-chan PublicationResult, error)
+
+/*
+Function wrapping a PublishFunc to add behaviour before and after the publication of an event
+The middleware sees the event before the publisher validates and serializes it, so it can modify its metadata
+*/
+type PublisherMiddleware func(PublishFunc) PublishFunc
+
+// wrappedPublisher implements ClosablePublisher, BatchPublisher and TypedPublisher
+type wrappedPublisher struct {
+	publisher   Publisher
+	middlewares []PublisherMiddleware
+}
+
+/*
+Wraps the publisher so every event goes through the given middlewares, in the given order,
+the first one being the outermost, for both PublishSync and PublishAsync
+The returned publisher is a ClosablePublisher forwarding Close to the wrapped one, a BatchPublisher
+using the batching of the wrapped one when available and a TypedPublisher accepting any payload when the wrapped one is not a TypedPublisher
+*/
+func WrapPublisher(publisher Publisher, middlewares ...PublisherMiddleware) Publisher {
+	return &wrappedPublisher{
+		publisher:   publisher,
+		middlewares: middlewares,
+	}
+}
+
+func (p *wrappedPublisher) PublishSync(ctx context.Context, event *Event) (string, error) {
+	publish := p.chain(func(ctx context.Context, event *Event) (<-chan PublicationResult, error) {
+		result, err := p.publisher.PublishSync(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		return resultChannel(PublicationResult{Result: result}), nil
+	})
+	res, err := publish(ctx, event)
+	if err != nil {
+		return "", err
+	}
+	result := <-res
+	return result.Result, result.Err
+}
+
+func (p *wrappedPublisher) PublishAsync(ctx context.Context, event *Event) (<-chan PublicationResult, error) {
+	return p.chain(p.publisher.PublishAsync)(ctx, event)
+}
+
+/*
+Sends every event through the middlewares and then all of them at once with PublishBatch of the wrapped publisher,
+the middlewares see the result of their event once the batch is done
+The events rejected by a middleware are left out of the batch with the error on their result
+The context given to the batch is the one of this call, so the values added to it by the middlewares, like the
+message size recorder, don't reach the wrapped publisher
+When the wrapped publisher is not a BatchPublisher every event is sent with PublishAsync, as PublishBatch does
+*/
+func (p *wrappedPublisher) PublishBatch(ctx context.Context, events []*Event) ([]PublicationResult, error) {
+	inner, ok := p.publisher.(BatchPublisher)
+	if !ok {
+		return publishEach(ctx, p.PublishAsync, events), nil
+	}
+	var batch []*Event
+	var pending []chan PublicationResult
+	out := make([]<-chan PublicationResult, len(events))
+	results := make([]PublicationResult, len(events))
+	for i, event := range events {
+		publish := p.chain(func(ctx context.Context, event *Event) (<-chan PublicationResult, error) {
+			res := make(chan PublicationResult, 1)
+			batch = append(batch, event)
+			pending = append(pending, res)
+			return res, nil
+		})
+		res, err := publish(ctx, event)
+		if err != nil {
+			results[i] = PublicationResult{Err: err}
+			continue
+		}
+		out[i] = res
+	}
+	var batchResults []PublicationResult
+	var batchErr error
+	if len(batch) > 0 {
+		batchResults, batchErr = inner.PublishBatch(ctx, batch)
+	}
+	for i, res := range pending {
+		if batchErr != nil {
+			res <- PublicationResult{Err: batchErr}
+		} else {
+			res <- batchResults[i]
+		}
+		close(res)
+	}
+	for i, res := range out {
+		if res != nil {
+			results[i] = <-res
+		}
+	}
+	if batchErr != nil {
+		return nil, batchErr
+	}
+	return results, nil
+}
+
+// Close closes the wrapped publisher if it is a ClosablePublisher
+func (p *wrappedPublisher) Close(ctx context.Context) error {
+	return ClosePublisher(ctx, p.publisher)
+}
+
+func (p *wrappedPublisher) chain(publish PublishFunc) PublishFunc {
+	for i := len(p.middlewares) - 1; i >= 0; i-- {
+		publish = p.middlewares[i](publish)
+	}
+	return publish
+}
+
+/*
+Creates a PublisherMiddleware from two optional hooks
+before is called with the event before it is sent, returning an error stops the publication
+after is called with the result of the publication once it is available, including the errors returned
+by the publisher, for asynchronous publications it is called before the result is delivered to the caller
+*/
+func PublisherInterceptor(before func(ctx context.Context, event *Event) error,
+	after func(ctx context.Context, event *Event, result PublicationResult)) PublisherMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, event *Event) (<-chan PublicationResult, error) {
+			if before != nil {
+				if err := before(ctx, event); err != nil {
+					return nil, err
+				}
+			}
+			res, err := next(ctx, event)
+			if after == nil {
+				return res, err
+			}
+			if err != nil {
+				after(ctx, event, PublicationResult{Err: err})
+				return nil, err
+			}
+			return OnPublicationResult(res, func(result PublicationResult) {
+				after(ctx, event, result)
+			}), nil
+		}
+	}
+}
+
+/*
+Returns a channel delivering the same result as the given one, calling the function with the result before
+*/
+func OnPublicationResult(res <-chan PublicationResult, f func(PublicationResult)) <-chan PublicationResult {
+	out := make(chan PublicationResult, 1)
+	go func() {
+		result, ok := <-res
+		if ok {
+			f(result)
+			out <- result
+		}
+		close(out)
+	}()
+	return out
+}
+
+func resultChannel(result PublicationResult) <-chan PublicationResult {
+	res := make(chan PublicationResult, 1)
+	res <- result
+	close(res)
+	return res
+}
+
+func (p *wrappedPublisher) AcceptsPayload(payload interface{}) bool {
+	if typed, ok := p.publisher.(TypedPublisher); ok {
+		return typed.AcceptsPayload(payload)
+	}
+	return true
+}
+
+type messageSizeKey struct{}
+
+/*
+Returns a context asking the publishers of this module to report the size of the messages they send with it,
+encoded in the wire format of the queue provider, calling the record function once per message
+*/
+func WithMessageSizeRecorder(ctx context.Context, record func(size int)) context.Context {
+	return context.WithValue(ctx, messageSizeKey{}, record)
+}
+
+// RecordMessageSize reports the size of an encoded message to the recorder of the context, if any
+func RecordMessageSize(ctx context.Context, size int) {
+	if record, ok := ctx.Value(messageSizeKey{}).(func(size int)); ok {
+		record(size)
+	}
+}
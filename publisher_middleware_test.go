@@ -0,0 +1,178 @@
+package queuesgo
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchPublisher records the batches it is given, failing the whole batch with err
+type batchPublisher struct {
+	batches [][]*Event
+	err     error
+}
+
+func (p *batchPublisher) PublishSync(ctx context.Context, event *Event) (string, error) {
+	return "", errors.New("not expected")
+}
+
+func (p *batchPublisher) PublishAsync(ctx context.Context, event *Event) (<-chan PublicationResult, error) {
+	return nil, errors.New("not expected")
+}
+
+func (p *batchPublisher) PublishBatch(ctx context.Context, events []*Event) ([]PublicationResult, error) {
+	p.batches = append(p.batches, events)
+	if p.err != nil {
+		return nil, p.err
+	}
+	results := make([]PublicationResult, len(events))
+	for i, event := range events {
+		results[i] = PublicationResult{Result: event.Metadata.ObjectID}
+	}
+	return results, nil
+}
+
+// eventPublisher records the events given to PublishSync and PublishAsync with the method called
+type eventPublisher struct {
+	calls  []string
+	events []*Event
+}
+
+func (p *eventPublisher) PublishSync(ctx context.Context, event *Event) (string, error) {
+	p.calls = append(p.calls, "sync")
+	p.events = append(p.events, event)
+	return "published " + event.Metadata.ObjectID, nil
+}
+
+func (p *eventPublisher) PublishAsync(ctx context.Context, event *Event) (<-chan PublicationResult, error) {
+	p.calls = append(p.calls, "async")
+	p.events = append(p.events, event)
+	return resultChannel(PublicationResult{Result: "published " + event.Metadata.ObjectID}), nil
+}
+
+// publishers returns PublishSync and PublishAsync of the publisher as the same function
+func publishers(publisher Publisher) map[string]func(ctx context.Context, event *Event) (string, error) {
+	return map[string]func(ctx context.Context, event *Event) (string, error){
+		"sync": publisher.PublishSync,
+		"async": func(ctx context.Context, event *Event) (string, error) {
+			res, err := publisher.PublishAsync(ctx, event)
+			if err != nil {
+				return "", err
+			}
+			result := <-res
+			return result.Result, result.Err
+		},
+	}
+}
+
+// recordingMiddleware records when it is called, before and after the next ones, and appends its name to the origin
+func recordingMiddleware(name string, calls *[]string) PublisherMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, event *Event) (<-chan PublicationResult, error) {
+			*calls = append(*calls, name+" before")
+			event.Metadata.Origin += name
+			res, err := next(ctx, event)
+			*calls = append(*calls, name+" after")
+			return res, err
+		}
+	}
+}
+
+func TestWrappedPublisherChain(t *testing.T) {
+	for method := range publishers(&eventPublisher{}) {
+		t.Run(method, func(t *testing.T) {
+			inner := &eventPublisher{}
+			var calls []string
+			publisher := WrapPublisher(inner, recordingMiddleware("first", &calls), recordingMiddleware("second", &calls))
+
+			result, err := publishers(publisher)[method](context.Background(), &Event{Metadata: EventMetadata{ObjectID: "1"}})
+
+			require.NoError(t, err)
+			assert.Equal(t, "published 1", result)
+			assert.Equal(t, []string{method}, inner.calls)
+			assert.Equal(t, "firstsecond", inner.events[0].Metadata.Origin)
+			assert.Equal(t, []string{"first before", "second before", "second after", "first after"}, calls)
+		})
+	}
+}
+
+func TestWrappedPublisherShortCircuit(t *testing.T) {
+	for method := range publishers(&eventPublisher{}) {
+		t.Run(method, func(t *testing.T) {
+			inner := &eventPublisher{}
+			var calls []string
+			rejecting := PublisherInterceptor(func(ctx context.Context, event *Event) error {
+				return errors.New("rejected")
+			}, nil)
+			publisher := WrapPublisher(inner, recordingMiddleware("first", &calls), rejecting, recordingMiddleware("second", &calls))
+
+			_, err := publishers(publisher)[method](context.Background(), &Event{})
+
+			assert.EqualError(t, err, "rejected")
+			assert.Empty(t, inner.calls)
+			assert.Equal(t, []string{"first before", "first after"}, calls)
+
+			cached := func(next PublishFunc) PublishFunc {
+				return func(ctx context.Context, event *Event) (<-chan PublicationResult, error) {
+					return resultChannel(PublicationResult{Result: "cached"}), nil
+				}
+			}
+			result, err := publishers(WrapPublisher(inner, cached))[method](context.Background(), &Event{})
+
+			require.NoError(t, err)
+			assert.Equal(t, "cached", result)
+			assert.Empty(t, inner.calls)
+		})
+	}
+}
+
+func TestWrappedPublisherBatch(t *testing.T) {
+	inner := &batchPublisher{}
+	var mu sync.Mutex
+	var seen []PublicationResult
+	publisher := WrapPublisher(inner, PublisherInterceptor(func(ctx context.Context, event *Event) error {
+		if event.Metadata.ObjectID == "2" {
+			return errors.New("rejected")
+		}
+		event.Metadata.Origin = "middleware"
+		return nil
+	}, func(ctx context.Context, event *Event, result PublicationResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, result)
+	}))
+	events := []*Event{
+		{Metadata: EventMetadata{ObjectID: "1"}},
+		{Metadata: EventMetadata{ObjectID: "2"}},
+		{Metadata: EventMetadata{ObjectID: "3"}},
+	}
+
+	results, err := PublishBatch(context.Background(), publisher, events)
+
+	require.NoError(t, err)
+	require.Len(t, inner.batches, 1)
+	require.Len(t, inner.batches[0], 2)
+	assert.Equal(t, "middleware", inner.batches[0][0].Metadata.Origin)
+	assert.Equal(t, []PublicationResult{{Result: "1"}, {Err: errors.New("rejected")}, {Result: "3"}}, results)
+	assert.ElementsMatch(t, []PublicationResult{{Result: "1"}, {Result: "3"}}, seen)
+}
+
+func TestWrappedPublisherBatchError(t *testing.T) {
+	inner := &batchPublisher{err: errors.New("invalid metadata")}
+	var mu sync.Mutex
+	var seen []PublicationResult
+	publisher := WrapPublisher(inner, PublisherInterceptor(nil, func(ctx context.Context, event *Event, result PublicationResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, result)
+	}))
+
+	_, err := PublishBatch(context.Background(), publisher, []*Event{{}, {}})
+
+	assert.Equal(t, inner.err, err)
+	assert.Equal(t, []PublicationResult{{Err: inner.err}, {Err: inner.err}}, seen)
+}
@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"cloud.google.com/go/pubsub"
+	"context"
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/internal/router"
+	"google.golang.org/api/option"
+)
+
+// Option configures the construction of a publisher or a subscriber
+type Option func(*options)
+
+type options struct {
+	client             *pubsub.Client
+	clientOptions      []option.ClientOption
+	logger             queuesgo.Logger
+	retryPolicy        *queuesgo.RetryPolicy
+	deadLetter         queuesgo.Publisher
+	unknownEventPolicy queuesgo.UnknownEventPolicy
+	logMode            bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.logger == nil {
+		level := queuesgo.LevelInfo
+		if o.logMode {
+			level = queuesgo.LevelDebug
+		}
+		o.logger = queuesgo.NewDefaultLogger(level)
+	}
+	return o
+}
+
+// routerConfig returns the options handled by the router of the subscriber reading from the subscription
+func (o *options) routerConfig(subscription string) router.Config {
+	config := router.Config{
+		Logger:             o.logger,
+		RetryPolicy:        o.retryPolicy,
+		UnknownEventPolicy: o.unknownEventPolicy,
+	}
+	if o.deadLetter != nil {
+		config.DeadLetter = &queuesgo.DeadLetterQueue{Publisher: o.deadLetter, Subscription: subscription}
+	}
+	return config
+}
+
+// pubsubClient returns the given client or creates a new one for the project with the client options
+func (o *options) pubsubClient(project string) (*pubsub.Client, error) {
+	if o.client != nil {
+		return o.client, nil
+	}
+	return pubsub.NewClient(context.Background(), project, o.clientOptions...)
+}
+
+// WithClient uses an existing Google's pubsub client instead of creating a new one, the project is ignored
+func WithClient(client *pubsub.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithClientOptions adds options used to create the Google's pubsub client
+func WithClientOptions(clientOptions ...option.ClientOption) Option {
+	return func(o *options) {
+		o.clientOptions = append(o.clientOptions, clientOptions...)
+	}
+}
+
+// WithCredentialsFile authenticates the Google's pubsub client with the given service account file
+func WithCredentialsFile(filename string) Option {
+	return WithClientOptions(option.WithCredentialsFile(filename))
+}
+
+// WithCredentialsJSON authenticates the Google's pubsub client with the given service account JSON
+func WithCredentialsJSON(credentials []byte) Option {
+	return WithClientOptions(option.WithCredentialsJSON(credentials))
+}
+
+// WithLogger writes the logs to the given logger instead of the standard error
+func WithLogger(logger queuesgo.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+/*
+Enables the logging of every received message and handled event on the default logger
+Deprecated: use WithLogger with a logger on debug level
+*/
+func WithLogMode(logMode bool) Option {
+	return func(o *options) {
+		o.logMode = logMode
+	}
+}
+
+/*
+Calls again the handler of an event not acknowledged, following the given policy, before leaving it to the queue provider
+The handlers are retried in process, so the message is held by the subscriber while waiting
+The delay of the policy also spaces the nacks of the messages left to Pub/Sub
+*/
+func WithRetryPolicy(policy queuesgo.RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = &policy
+	}
+}
+
+/*
+Republishes through the given publisher the events not acknowledged once the retries are exhausted,
+acknowledging the original message. The events carry the dead letter attributes on the extra metadata
+A publisher created with a map[string]interface{} type takes the payloads of every typed handler
+*/
+func WithDeadLetter(publisher queuesgo.Publisher) Option {
+	return func(o *options) {
+		o.deadLetter = publisher
+	}
+}
+
+/*
+Sets what the subscriber does with the events without a registered handler nor a fallback, acknowledging them by default
+DeadLetterUnknownEvents requires WithDeadLetter, the subscriber cannot be created otherwise
+*/
+func WithUnknownEventPolicy(policy queuesgo.UnknownEventPolicy) Option {
+	return func(o *options) {
+		o.unknownEventPolicy = policy
+	}
+}
@@ -5,13 +5,18 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/merlinapp/queues-go/internal/inflight"
 	"reflect"
 )
 
 type publisher struct {
-	topic      *pubsub.Topic
-	objectType reflect.Type
+	client       *pubsub.Client
+	ownClient    bool // the client was created by the publisher, so it is closed with it
+	topic        *pubsub.Topic
+	objectType   reflect.Type
+	publications inflight.Publications
 }
 
 /*
@@ -21,17 +26,46 @@ the objectType interface should be any of the following types, any other type wi
 1. Copy of a structure
 2. Non-nil pointer to a struct of the expected type.
 3. A map with key string and any value
+Deprecated: use NewPublisherWithOptions, which returns the cause of the failure
 */
 func NewPublisher(project, topic string, objectType interface{}) queuesgo.Publisher {
-	if !queuesgo.ValidateType(objectType) {
+	p, err := NewPublisherWithOptions(project, topic, objectType)
+	if err != nil {
 		return nil
 	}
-	pubsubClient, _ := pubsub.NewClient(context.Background(), project)
-	t := pubsubClient.Topic(topic)
+	return p
+}
+
+/*
+Creates a new Google's pubsub publisher
+The topic must already exist in the given project.
+the objectType interface should be any of the following types, any other type will return an error
+1. Copy of a structure
+2. Non-nil pointer to a struct of the expected type.
+3. A map with key string and any value
+Returns an error if the Google's pubsub client cannot be created
+The publisher is a queuesgo.BatchPublisher, a queuesgo.ClosablePublisher and a queuesgo.TypedPublisher,
+closing it closes the client unless it was given with WithClient
+*/
+func NewPublisherWithOptions(project, topic string, objectType interface{}, opts ...Option) (queuesgo.Publisher, error) {
+	if !queuesgo.ValidateType(objectType) {
+		return nil, errors.New("invalid object type")
+	}
+	o := newOptions(opts)
+	pubsubClient, err := o.pubsubClient(project)
+	if err != nil {
+		return nil, err
+	}
 	return &publisher{
-		topic:      t,
+		client:     pubsubClient,
+		ownClient:  o.client == nil,
+		topic:      pubsubClient.Topic(topic),
 		objectType: reflect.TypeOf(objectType),
-	}
+	}, nil
+}
+
+func (p *publisher) AcceptsPayload(payload interface{}) bool {
+	return queuesgo.ValidateRegisteredType(payload, p.objectType)
 }
 
 func (p *publisher) PublishSync(ctx context.Context, event *queuesgo.Event) (string, error) {
@@ -39,8 +73,14 @@ func (p *publisher) PublishSync(ctx context.Context, event *queuesgo.Event) (str
 	if err != nil {
 		return "", err
 	}
+	if err = p.publications.Add(1); err != nil {
+		return "", err
+	}
 	result := p.topic.Publish(ctx, message)
-	return result.Get(ctx)
+	queuesgo.RecordMessageSize(ctx, len(message.Data))
+	id, err := result.Get(ctx)
+	p.publications.Done(err)
+	return id, err
 }
 
 func (p *publisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
@@ -48,15 +88,78 @@ func (p *publisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-
 	if err != nil {
 		return nil, err
 	}
+	if err = p.publications.Add(1); err != nil {
+		return nil, err
+	}
 	res := make(chan queuesgo.PublicationResult, 1)
 	result := p.topic.Publish(ctx, message)
+	queuesgo.RecordMessageSize(ctx, len(message.Data))
 	go func() {
 		s, err := result.Get(ctx)
-		p := queuesgo.PublicationResult{Result: s, Err: err}
-		res <- p
+		p.publications.Done(err)
+		res <- queuesgo.PublicationResult{Result: s, Err: err}
 		close(res)
 	}()
-	return res, err
+	return res, nil
+}
+
+/*
+Sends the events through the batcher of the topic, which groups them in as few requests as its settings allow
+*/
+func (p *publisher) PublishBatch(ctx context.Context, events []*queuesgo.Event) ([]queuesgo.PublicationResult, error) {
+	messages := make([]*pubsub.Message, len(events))
+	for i, event := range events {
+		message, err := p.eventToPubSub(event)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		messages[i] = message
+	}
+	if err := p.publications.Add(len(messages)); err != nil {
+		return nil, err
+	}
+	pending := make([]*pubsub.PublishResult, len(messages))
+	for i, message := range messages {
+		pending[i] = p.topic.Publish(ctx, message)
+		queuesgo.RecordMessageSize(ctx, len(message.Data))
+	}
+	results := make([]queuesgo.PublicationResult, len(pending))
+	for i, result := range pending {
+		id, err := result.Get(ctx)
+		p.publications.Done(err)
+		results[i] = queuesgo.PublicationResult{Result: id, Err: err}
+	}
+	return results, nil
+}
+
+/*
+Sends the buffered messages and waits for the outstanding publications until the context is done,
+then stops the topic and closes the client if it was created by the publisher
+*/
+func (p *publisher) Close(ctx context.Context) error {
+	if err := p.publications.Close(); err != nil {
+		return err
+	}
+	stopped := make(chan struct{})
+	go func() {
+		// Stop sends the buffered messages right away instead of waiting for the bundle thresholds
+		p.topic.Stop()
+		close(stopped)
+	}()
+	errs := p.publications.Wait(ctx)
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+	}
+	if p.ownClient {
+		if err := p.client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
 func (p *publisher) eventToPubSub(event *queuesgo.Event) (*pubsub.Message, error) {
@@ -70,17 +173,8 @@ func (p *publisher) eventToPubSub(event *queuesgo.Event) (*pubsub.Message, error
 	if event.Metadata.IsZero() {
 		return nil, errors.New("invalid metadata")
 	}
-	attributes := make(map[string]string)
-	attributes["correlation_id"] = event.Metadata.CorrelationID
-	attributes["event_name"] = event.Metadata.EventName
-	attributes["origin"] = event.Metadata.Origin
-	attributes["object_id"] = event.Metadata.ObjectID
-	attributes["timestamp"] = string(event.Metadata.Timestamp)
-	if event.Metadata.UserID != "" {
-		attributes["user_id"] = event.Metadata.UserID
-	}
 	message := &pubsub.Message{
-		Attributes: attributes,
+		Attributes: event.Metadata.Attributes(),
 		Data:       data,
 	}
 	return message, nil
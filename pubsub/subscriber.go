@@ -5,24 +5,20 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	queuesgo "github.com/merlinapp/queues-go"
-	"log"
+	"github.com/merlinapp/queues-go/internal/router"
 	"reflect"
-	"strconv"
+	"time"
 )
 
+// defaultRedeliveryPolicy spaces the redeliveries of a message when the subscriber doesn't have a retry policy
+var defaultRedeliveryPolicy = queuesgo.DefaultRetryPolicy
+
 type subscriber struct {
-	project          string
+	client           *pubsub.Client
 	subscriptionName string
-	elements         []routerElement
-	objectType       reflect.Type
-	logMode          bool
-}
-
-type routerElement struct {
-	event       string
-	handlerFunc queuesgo.HandlerFunc
+	router           *router.Router
+	redeliveryPolicy queuesgo.RetryPolicy
 }
 
 /*
@@ -32,94 +28,114 @@ the objectType interface should be any of the following types, any other type wi
 1. Copy of a structure
 2. Non-nil pointer to a struct of the expected type.
 3. A map with key string and any value
+Deprecated: use NewSubscriberWithOptions, which returns the cause of the failure
 */
 func NewSubscriber(project, subscriptionName string, objectType interface{}, logMode bool) queuesgo.Subscriber {
-	if !queuesgo.ValidateType(objectType) {
+	s, err := NewSubscriberWithOptions(project, subscriptionName, objectType, WithLogMode(logMode))
+	if err != nil {
 		return nil
 	}
+	return s
+}
+
+/*
+Creates a new Google's pubsub subscriber implementation
+the subscription name must exists already on the given projects
+the objectType interface should be any of the following types, any other type will return an error
+1. Copy of a structure
+2. Non-nil pointer to a struct of the expected type.
+3. A map with key string and any value
+Returns an error if the Google's pubsub client cannot be created
+The subscriber is a queuesgo.RoutingSubscriber and a queuesgo.MiddlewareSubscriber
+*/
+func NewSubscriberWithOptions(project, subscriptionName string, objectType interface{}, opts ...Option) (queuesgo.Subscriber, error) {
+	if !queuesgo.ValidateType(objectType) {
+		return nil, errors.New("invalid object type")
+	}
+	o := newOptions(opts)
+	r, err := router.New(reflect.TypeOf(objectType), o.routerConfig(subscriptionName))
+	if err != nil {
+		return nil, err
+	}
+	pubsubClient, err := o.pubsubClient(project)
+	if err != nil {
+		return nil, err
+	}
+	redeliveryPolicy := defaultRedeliveryPolicy
+	if o.retryPolicy != nil && o.retryPolicy.InitialDelay > 0 {
+		redeliveryPolicy = *o.retryPolicy
+	}
 	return &subscriber{
-		project:          project,
+		client:           pubsubClient,
 		subscriptionName: subscriptionName,
-		objectType:       reflect.TypeOf(objectType),
-		logMode:          logMode,
-	}
+		router:           r,
+		redeliveryPolicy: redeliveryPolicy,
+	}, nil
 }
 
 func (s *subscriber) RegisterFunction(eventName string, handler queuesgo.HandlerFunc) error {
-	if eventName == "" {
-		return errors.New("invalid event name")
-	}
-	s.elements = append(s.elements, routerElement{event: eventName, handlerFunc: handler})
-	return nil
+	return s.router.RegisterFunction(eventName, handler)
+}
+
+func (s *subscriber) RegisterTypedFunction(eventName string, payloadType interface{}, handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterTypedFunction(eventName, payloadType, handler)
+}
+
+func (s *subscriber) RegisterMatchFunction(match queuesgo.EventMatcher, handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterMatchFunction(match, handler)
+}
+
+func (s *subscriber) RegisterFallback(handler queuesgo.HandlerFunc) error {
+	return s.router.RegisterFallback(handler)
+}
+
+func (s *subscriber) Use(middlewares ...queuesgo.Middleware) {
+	s.router.Use(middlewares...)
 }
 
 func (s *subscriber) Subscribe(ctx context.Context) error {
-	pubsubClient, _ := pubsub.NewClient(ctx, s.project)
-	sub := pubsubClient.Subscription(s.subscriptionName)
+	sub := s.client.Subscription(s.subscriptionName)
 	err := sub.Receive(ctx, func(ctx context.Context, message *pubsub.Message) {
-		s.logger(fmt.Sprintf("Received message: %s", message.Data))
 		event := s.pubsubToEvent(message)
-		ack := s.manager(ctx, event)
+		s.router.Logger().Debug("Received message", append(queuesgo.EventFields(event),
+			"message_id", message.ID, "subscription", s.subscriptionName, "data", string(message.Data))...)
+		ack := s.router.Manager(ctx, event)
 		if ack {
 			message.Ack()
+		} else {
+			s.redeliver(ctx, message)
 		}
 	})
 	return err
 }
 
-func (s *subscriber) manager(ctx context.Context, event queuesgo.Event) bool {
-	if !queuesgo.ValidateRegisteredType(event.Payload, s.objectType) {
-		panic("the received event cannot be used on the registered type")
+/*
+Nacks the message after the delay of its delivery attempt, so Pub/Sub resends it right away
+The attempt is only known when the subscription has a dead letter policy, otherwise the first delay is used
+*/
+func (s *subscriber) redeliver(ctx context.Context, message *pubsub.Message) {
+	attempt := 1
+	if message.DeliveryAttempt != nil {
+		attempt = *message.DeliveryAttempt
 	}
-	eventName := event.Metadata.EventName
-	for _, element := range s.elements {
-		if element.event == eventName {
-			ack, err := element.handlerFunc(ctx, event)
-			// The acknowledgment of the message is handled by the handlerFunction regardless of the error
-			if err != nil {
-				log.Println(fmt.Sprintf("An error: %s for event: %s", err.Error(), eventName))
-				return ack
-			}
-			s.logger(fmt.Sprintf("Operation: %s was called for event", eventName))
-			return ack
-		}
+	timer := time.NewTimer(s.redeliveryPolicy.Delay(attempt))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
 	}
-	log.Printf("No function was registered for the event: %s", eventName)
-	return true
+	message.Nack()
 }
 
 func (s *subscriber) pubsubToEvent(psMessage *pubsub.Message) queuesgo.Event {
-	attributes := psMessage.Attributes
-	intTimestamp, _ := strconv.ParseInt(attributes["timestamp"], 10, 64)
-
-	var payload interface{}
-	if s.objectType.Kind() == reflect.Ptr {
-		payload = reflect.New(s.objectType.Elem()).Interface()
-	} else {
-		payload = reflect.New(s.objectType).Interface()
-	}
+	metadata := queuesgo.NewEventMetadata(psMessage.Attributes)
+	// The event name picks the payload type when there are typed handlers
+	payload := s.router.NewPayload(metadata)
 
 	_ = json.Unmarshal(psMessage.Data, payload)
 
-	event := queuesgo.Event{
-		Payload: payload,
-		Metadata: queuesgo.EventMetadata{
-			CorrelationID: attributes["correlation_id"],
-			EventName:     attributes["event_name"],
-			Origin:        attributes["origin"],
-			Timestamp:     intTimestamp,
-			ObjectID:      attributes["object_id"],
-		},
-	}
-	userID, ok := attributes["user_id"]
-	if ok {
-		event.Metadata.UserID = userID
-	}
-	return event
-}
-
-func (s *subscriber) logger(message string) {
-	if s.logMode {
-		log.Println(message)
+	return queuesgo.Event{
+		Payload:  payload,
+		Metadata: metadata,
 	}
 }
@@ -0,0 +1,100 @@
+package queuesgo
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+/*
+Policy to call again a handler that doesn't acknowledge an event, waiting an exponential delay between the attempts
+After the last attempt the event is not acknowledged, leaving the resend to the queue provider
+*/
+type RetryPolicy struct {
+	MaxAttempts  int           // Total times the handler is called for an event, 1 or less disables the retries
+	InitialDelay time.Duration // Delay before the second attempt
+	MaxDelay     time.Duration // Upper bound of the delay between attempts, 0 for no bound
+	Multiplier   float64       // Factor applied to the delay after each attempt, 1 or less keeps the delay constant
+	Jitter       float64       // Fraction of the delay randomly added or subtracted, between 0 and 1
+}
+
+// DefaultRetryPolicy retries 5 times starting with 100ms and doubling the delay up to 10s
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     10 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+type attemptKey struct{}
+
+// runningKey holds the channel set by the Timeout middleware, closed when the handler it stopped waiting for returns
+type runningKey struct{}
+
+/*
+Returns the attempt number of the event being handled, starting on 1
+When the subscriber doesn't have a retry policy it is always 1
+*/
+func Attempt(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// Delay returns the time to wait after the given failed attempt
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	if p.Multiplier > 1 {
+		delay *= math.Pow(p.Multiplier, float64(attempt-1))
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	return time.Duration(delay)
+}
+
+/*
+Calls the handler again while it doesn't acknowledge the event, following the policy
+The attempt number is available to the handler through the Attempt function
+Returns the result of the last attempt, or of the previous one if the context is done while waiting
+When the Timeout middleware is inside the Retry one, the next attempt also waits for the timed out handler to return,
+so the handler is never running twice for the same event
+*/
+func Retry(policy RetryPolicy) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, event Event) (bool, error) {
+			for attempt := 1; ; attempt++ {
+				recordAttempt(ctx, attempt)
+				var running <-chan struct{}
+				attemptCtx := context.WithValue(context.WithValue(ctx, attemptKey{}, attempt), runningKey{}, &running)
+				ack, err := next(attemptCtx, event)
+				if ack || attempt >= policy.MaxAttempts {
+					return ack, err
+				}
+				timer := time.NewTimer(policy.Delay(attempt))
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return ack, err
+				}
+				if running != nil {
+					select {
+					case <-running:
+					case <-ctx.Done():
+						return ack, err
+					}
+				}
+			}
+		}
+	}
+}
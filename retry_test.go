@@ -0,0 +1,92 @@
+package queuesgo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingHandler doesn't acknowledge the events until the given attempt, recording the attempts seen
+func failingHandler(ackOn int, attempts *[]int) HandlerFunc {
+	return func(ctx context.Context, event Event) (bool, error) {
+		*attempts = append(*attempts, Attempt(ctx))
+		if Attempt(ctx) == ackOn {
+			return true, nil
+		}
+		return false, errors.New("unavailable")
+	}
+}
+
+func TestAttemptWithoutRetry(t *testing.T) {
+	assert.Equal(t, 1, Attempt(context.Background()))
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}
+
+	var attempts []int
+	ack, err := Retry(policy)(failingHandler(2, &attempts))(context.Background(), Event{})
+	assert.True(t, ack)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, attempts)
+
+	attempts = nil
+	ack, err = Retry(policy)(failingHandler(0, &attempts))(context.Background(), Event{})
+	assert.False(t, ack)
+	assert.EqualError(t, err, "unavailable")
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+
+	attempts = nil
+	ack, _ = Retry(RetryPolicy{})(failingHandler(0, &attempts))(context.Background(), Event{})
+	assert.False(t, ack)
+	assert.Equal(t, []int{1}, attempts)
+}
+
+func TestRetryStopsOnTheContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var attempts []int
+
+	start := time.Now()
+	ack, err := Retry(RetryPolicy{MaxAttempts: 3, InitialDelay: 5 * time.Second})(failingHandler(0, &attempts))(ctx, Event{})
+
+	assert.False(t, ack)
+	assert.EqualError(t, err, "unavailable")
+	assert.Equal(t, []int{1}, attempts)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		attempt  int
+		expected time.Duration
+	}{
+		{name: "first attempt", policy: RetryPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 2}, attempt: 1, expected: 100 * time.Millisecond},
+		{name: "growing", policy: RetryPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 2}, attempt: 3, expected: 400 * time.Millisecond},
+		{name: "capped", policy: RetryPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 2, MaxDelay: time.Second}, attempt: 10, expected: time.Second},
+		{name: "constant", policy: RetryPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 1}, attempt: 3, expected: 100 * time.Millisecond},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.policy.Delay(test.attempt))
+		})
+	}
+}
+
+func TestRetryPolicyDelayWithJitter(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: 100 * time.Millisecond, Multiplier: 2, MaxDelay: time.Second, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(2)
+		assert.GreaterOrEqual(t, int64(delay), int64(160*time.Millisecond))
+		assert.LessOrEqual(t, int64(delay), int64(240*time.Millisecond))
+		// the jitter doesn't go over the maximum delay
+		delay = policy.Delay(10)
+		assert.GreaterOrEqual(t, int64(delay), int64(800*time.Millisecond))
+		assert.LessOrEqual(t, int64(delay), int64(time.Second))
+	}
+}
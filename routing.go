@@ -0,0 +1,68 @@
+package queuesgo
+
+import "path"
+
+/*
+Function deciding if a route of a subscriber handles the event with the given metadata
+*/
+type EventMatcher func(metadata EventMetadata) bool
+
+// MatchOrigin matches the events sent by any of the given origins
+func MatchOrigin(origins ...string) EventMatcher {
+	return func(metadata EventMetadata) bool {
+		for _, origin := range origins {
+			if metadata.Origin == origin {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+/*
+MatchEventName matches the events with a name matching the glob pattern, as path.Match
+An invalid pattern doesn't match any event
+*/
+func MatchEventName(pattern string) EventMatcher {
+	return func(metadata EventMetadata) bool {
+		matched, _ := path.Match(pattern, metadata.EventName)
+		return matched
+	}
+}
+
+// MatchAll matches the events matched by all the given matchers
+func MatchAll(matchers ...EventMatcher) EventMatcher {
+	return func(metadata EventMetadata) bool {
+		for _, match := range matchers {
+			if !match(metadata) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// What a subscriber does with the events without a registered handler nor a fallback
+type UnknownEventPolicy int
+
+const (
+	// AckUnknownEvents acknowledges the events, so they are lost, it is the default policy
+	AckUnknownEvents UnknownEventPolicy = iota
+	// NackUnknownEvents doesn't acknowledge the events, leaving the resend to the queue provider
+	NackUnknownEvents
+	// DeadLetterUnknownEvents sends the events to the dead letter queue of the subscriber, acknowledging them afterwards
+	DeadLetterUnknownEvents
+)
+
+func (p UnknownEventPolicy) String() string {
+	switch p {
+	case AckUnknownEvents:
+		return "ack"
+	case NackUnknownEvents:
+		return "nack"
+	case DeadLetterUnknownEvents:
+		return "dead_letter"
+	default:
+		return "unknown"
+	}
+}
@@ -2,13 +2,16 @@ package queuesgo
 
 import (
 	"context"
+	"errors"
 )
 
 type Subscriber interface {
 	/*
 		Register the function to ve available on the subscription
-		eventName: event that needs to be handled
+		eventName: event that needs to be handled, it can be a glob pattern as path.Match like order.*
 		handler: the handler function that will be called when an event with the given name is the eventName given
+		The handlers registered with the exact event name are preferred, then the patterns and the matchers,
+		each in the order they were registered, then the fallback
 	*/
 	RegisterFunction(eventName string, handler HandlerFunc) error
 	/*
@@ -17,9 +20,83 @@ type Subscriber interface {
 	Subscribe(ctx context.Context) error
 }
 
+/*
+Subscriber routing the events by more than their name, implemented by the subscribers of this module
+Use the RegisterTypedFunction, RegisterMatchFunction and RegisterFallback functions to register on any Subscriber
+*/
+type RoutingSubscriber interface {
+	Subscriber
+	/*
+		Register the function for the events with the given name, decoding their payload into the payloadType
+		instead of the type of the subscriber, so one subscription can carry events with different payloads
+		payloadType: a structure, a non-nil pointer to a structure or a map with string keys, as the subscriber type
+		Returns an error if the dead letter publisher of the subscriber doesn't accept the payloadType, see DeadLetterQueue
+	*/
+	RegisterTypedFunction(eventName string, payloadType interface{}, handler HandlerFunc) error
+	/*
+		Register the function for the events matched by the given function, for example by their origin
+		The matchers are checked after the patterns, in the order they were registered
+	*/
+	RegisterMatchFunction(match EventMatcher, handler HandlerFunc) error
+	/*
+		Register the function for the events without any other handler, instead of applying the unknown event policy
+	*/
+	RegisterFallback(handler HandlerFunc) error
+}
+
+/*
+Subscriber applying middlewares around its handlers, implemented by the subscribers of this module
+Use the Use function to add them to any Subscriber
+*/
+type MiddlewareSubscriber interface {
+	Subscriber
+	/*
+		Adds middlewares applied around the registered handlers, in the given order, the first one being the outermost
+		The middlewares apply to every handled event, regardless of when the handler was registered
+	*/
+	Use(middlewares ...Middleware)
+}
+
+// ErrNotSupported is returned when the subscriber doesn't implement the extension interface needed by the call
+var ErrNotSupported = errors.New("not supported by the subscriber")
+
+// RegisterTypedFunction registers the typed handler if the subscriber is a RoutingSubscriber, returns ErrNotSupported otherwise
+func RegisterTypedFunction(subscriber Subscriber, eventName string, payloadType interface{}, handler HandlerFunc) error {
+	if routing, ok := subscriber.(RoutingSubscriber); ok {
+		return routing.RegisterTypedFunction(eventName, payloadType, handler)
+	}
+	return ErrNotSupported
+}
+
+// RegisterMatchFunction registers the handler if the subscriber is a RoutingSubscriber, returns ErrNotSupported otherwise
+func RegisterMatchFunction(subscriber Subscriber, match EventMatcher, handler HandlerFunc) error {
+	if routing, ok := subscriber.(RoutingSubscriber); ok {
+		return routing.RegisterMatchFunction(match, handler)
+	}
+	return ErrNotSupported
+}
+
+// RegisterFallback registers the fallback if the subscriber is a RoutingSubscriber, returns ErrNotSupported otherwise
+func RegisterFallback(subscriber Subscriber, handler HandlerFunc) error {
+	if routing, ok := subscriber.(RoutingSubscriber); ok {
+		return routing.RegisterFallback(handler)
+	}
+	return ErrNotSupported
+}
+
+// Use adds the middlewares if the subscriber is a MiddlewareSubscriber, returns ErrNotSupported otherwise
+func Use(subscriber Subscriber, middlewares ...Middleware) error {
+	if middlewareSubscriber, ok := subscriber.(MiddlewareSubscriber); ok {
+		middlewareSubscriber.Use(middlewares...)
+		return nil
+	}
+	return ErrNotSupported
+}
+
 /*
 Function that will handle the Event received, it should return if the message should be acknowledge to the queue
 provider, true to ack, false to indicate a eventual resend (The resend will depend of the subscriber implementation)
-The event payload will contain the information of the registered object type (If the registered type wasn't a pointer, it will return a pointer)
+The event payload will contain the information of the registered object type (If the registered type wasn't a pointer, it will return a pointer),
+or of the payload type of the handler when it was registered with RegisterTypedFunction
 */
 type HandlerFunc func(context.Context, Event) (bool, error)
@@ -0,0 +1,67 @@
+package queuesgo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// basicSubscriber implements only the core Subscriber interface, as the subscribers outside this module
+type basicSubscriber struct{}
+
+func (basicSubscriber) RegisterFunction(eventName string, handler HandlerFunc) error {
+	return nil
+}
+
+func (basicSubscriber) Subscribe(ctx context.Context) error {
+	return nil
+}
+
+type routingSubscriber struct {
+	basicSubscriber
+	registered  []string
+	middlewares int
+}
+
+func (s *routingSubscriber) RegisterTypedFunction(eventName string, payloadType interface{}, handler HandlerFunc) error {
+	s.registered = append(s.registered, "typed "+eventName)
+	return nil
+}
+
+func (s *routingSubscriber) RegisterMatchFunction(match EventMatcher, handler HandlerFunc) error {
+	s.registered = append(s.registered, "match")
+	return nil
+}
+
+func (s *routingSubscriber) RegisterFallback(handler HandlerFunc) error {
+	s.registered = append(s.registered, "fallback")
+	return nil
+}
+
+func (s *routingSubscriber) Use(middlewares ...Middleware) {
+	s.middlewares += len(middlewares)
+}
+
+func TestExtensionHelpers(t *testing.T) {
+	handler := func(ctx context.Context, event Event) (bool, error) { return true, nil }
+	subscriber := &routingSubscriber{}
+
+	assert.NoError(t, RegisterTypedFunction(subscriber, "created", struct{}{}, handler))
+	assert.NoError(t, RegisterMatchFunction(subscriber, MatchOrigin("orders"), handler))
+	assert.NoError(t, RegisterFallback(subscriber, handler))
+	assert.NoError(t, Use(subscriber, Recovery(), Logging(NewDefaultLogger(LevelInfo))))
+
+	assert.Equal(t, []string{"typed created", "match", "fallback"}, subscriber.registered)
+	assert.Equal(t, 2, subscriber.middlewares)
+}
+
+func TestExtensionHelpersNotSupported(t *testing.T) {
+	handler := func(ctx context.Context, event Event) (bool, error) { return true, nil }
+	var subscriber Subscriber = basicSubscriber{}
+
+	assert.Equal(t, ErrNotSupported, RegisterTypedFunction(subscriber, "created", struct{}{}, handler))
+	assert.Equal(t, ErrNotSupported, RegisterMatchFunction(subscriber, MatchOrigin("orders"), handler))
+	assert.Equal(t, ErrNotSupported, RegisterFallback(subscriber, handler))
+	assert.Equal(t, ErrNotSupported, Use(subscriber, Recovery()))
+}
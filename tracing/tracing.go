@@ -0,0 +1,153 @@
+/*
+Package tracing propagates the OpenTelemetry trace context through the events,
+using the W3C traceparent and tracestate attributes on the extra metadata
+*/
+package tracing
+
+import (
+	"context"
+	queuesgo "github.com/merlinapp/queues-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/merlinapp/queues-go/tracing"
+
+// Attributes of the producer and consumer spans
+const (
+	DestinationKey   = attribute.Key("messaging.destination.name")
+	SubscriptionKey  = attribute.Key("messaging.destination.subscription.name")
+	OperationKey     = attribute.Key("messaging.operation")
+	ConversationKey  = attribute.Key("messaging.message.conversation_id")
+	MessageIDKey     = attribute.Key("messaging.message.id")
+	EventNameKey     = attribute.Key("event_name")
+	AcknowledgedKey  = attribute.Key("acknowledged")
+	publishOperation = "publish"
+	processOperation = "process"
+)
+
+// Option configures the tracing middlewares
+type Option func(*config)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	propagator     propagation.TextMapPropagator
+	topic          string
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.tracerProvider == nil {
+		c.tracerProvider = otel.GetTracerProvider()
+	}
+	if c.propagator == nil {
+		c.propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	}
+	return c
+}
+
+// WithTracerProvider creates the spans with the given provider instead of the global one
+func WithTracerProvider(tracerProvider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = tracerProvider
+	}
+}
+
+// WithPropagator uses the given propagator instead of the W3C trace context and baggage ones
+func WithPropagator(propagator propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = propagator
+	}
+}
+
+// WithTopic sets the topic read by the subscription on the consumer spans, ignored by the publisher middleware
+func WithTopic(topic string) Option {
+	return func(c *config) {
+		c.topic = topic
+	}
+}
+
+/*
+Starts a producer span for every published event and injects its context on the extra metadata of the event,
+so the queue providers send it as message attributes or headers. The event given by the caller is not modified
+The span ends when the publication result is available
+*/
+func PublisherMiddleware(topic string, opts ...Option) queuesgo.PublisherMiddleware {
+	c := newConfig(opts)
+	tracer := c.tracerProvider.Tracer(instrumentationName)
+	return func(next queuesgo.PublishFunc) queuesgo.PublishFunc {
+		return func(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
+			ctx, span := tracer.Start(ctx, topic+" "+publishOperation,
+				trace.WithSpanKind(trace.SpanKindProducer),
+				trace.WithAttributes(
+					DestinationKey.String(topic),
+					OperationKey.String(publishOperation),
+					EventNameKey.String(event.Metadata.EventName),
+					ConversationKey.String(event.Metadata.CorrelationID),
+				))
+			extra := make(map[string]string, len(event.Metadata.Extra)+2)
+			for key, value := range event.Metadata.Extra {
+				extra[key] = value
+			}
+			c.propagator.Inject(ctx, propagation.MapCarrier(extra))
+			injected := *event
+			injected.Metadata.Extra = extra
+
+			res, err := next(ctx, &injected)
+			if err != nil {
+				endSpan(span, err)
+				return nil, err
+			}
+			return queuesgo.OnPublicationResult(res, func(result queuesgo.PublicationResult) {
+				if result.Err == nil && result.Result != "" {
+					span.SetAttributes(MessageIDKey.String(result.Result))
+				}
+				endSpan(span, result.Err)
+			}), nil
+		}
+	}
+}
+
+/*
+Extracts the trace context of the event into the handler context, starting a consumer span as its child
+It should be the first middleware so the others run inside the span, the topic is set with WithTopic
+*/
+func Middleware(subscription string, opts ...Option) queuesgo.Middleware {
+	c := newConfig(opts)
+	tracer := c.tracerProvider.Tracer(instrumentationName)
+	return func(next queuesgo.HandlerFunc) queuesgo.HandlerFunc {
+		return func(ctx context.Context, event queuesgo.Event) (bool, error) {
+			ctx = c.propagator.Extract(ctx, propagation.MapCarrier(event.Metadata.Extra))
+			attributes := []attribute.KeyValue{
+				SubscriptionKey.String(subscription),
+				OperationKey.String(processOperation),
+				EventNameKey.String(event.Metadata.EventName),
+				ConversationKey.String(event.Metadata.CorrelationID),
+			}
+			if c.topic != "" {
+				attributes = append(attributes, DestinationKey.String(c.topic))
+			}
+			ctx, span := tracer.Start(ctx, subscription+" "+processOperation,
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(attributes...))
+			ack, err := next(ctx, event)
+			span.SetAttributes(AcknowledgedKey.Bool(ack))
+			endSpan(span, err)
+			return ack, err
+		}
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
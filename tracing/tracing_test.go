@@ -0,0 +1,128 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	queuesgo "github.com/merlinapp/queues-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// capturingPublisher keeps the events published, failing with err
+type capturingPublisher struct {
+	events []*queuesgo.Event
+	err    error
+}
+
+func (p *capturingPublisher) PublishSync(ctx context.Context, event *queuesgo.Event) (string, error) {
+	if p.err != nil {
+		return "", p.err
+	}
+	p.events = append(p.events, event)
+	return "message-1", nil
+}
+
+func (p *capturingPublisher) PublishAsync(ctx context.Context, event *queuesgo.Event) (<-chan queuesgo.PublicationResult, error) {
+	return nil, errors.New("not expected")
+}
+
+func newRecorder() (*tracetest.SpanRecorder, []Option) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	return recorder, []Option{WithTracerProvider(provider), WithPropagator(propagation.TraceContext{})}
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, attribute := range span.Attributes() {
+		values[string(attribute.Key)] = attribute.Value.AsInterface()
+	}
+	return values
+}
+
+func TestPublishAndReceive(t *testing.T) {
+	recorder, opts := newRecorder()
+	inner := &capturingPublisher{}
+	publisher := queuesgo.WrapPublisher(inner, PublisherMiddleware("orders", opts...))
+	event := &queuesgo.Event{Metadata: queuesgo.EventMetadata{EventName: "order.created", CorrelationID: "correlation"}}
+
+	result, err := publisher.PublishSync(context.Background(), event)
+
+	require.NoError(t, err)
+	assert.Equal(t, "message-1", result)
+	assert.Nil(t, event.Metadata.Extra, "the event of the caller is not modified")
+	require.Len(t, inner.events, 1)
+	require.Contains(t, inner.events[0].Metadata.Extra, "traceparent")
+	require.Len(t, recorder.Ended(), 1)
+	producer := recorder.Ended()[0]
+	assert.Equal(t, "orders publish", producer.Name())
+	assert.Equal(t, trace.SpanKindProducer, producer.SpanKind())
+	assert.Equal(t, map[string]interface{}{
+		"messaging.destination.name":        "orders",
+		"messaging.operation":               "publish",
+		"event_name":                        "order.created",
+		"messaging.message.conversation_id": "correlation",
+		"messaging.message.id":              "message-1",
+	}, attributes(producer))
+
+	var handlerSpan trace.SpanContext
+	handler := Middleware("orders-subscription", append(opts, WithTopic("orders"))...)(func(ctx context.Context, event queuesgo.Event) (bool, error) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return true, nil
+	})
+	ack, err := handler(context.Background(), *inner.events[0])
+
+	require.NoError(t, err)
+	assert.True(t, ack)
+	require.Len(t, recorder.Ended(), 2)
+	consumer := recorder.Ended()[1]
+	assert.Equal(t, "orders-subscription process", consumer.Name())
+	assert.Equal(t, trace.SpanKindConsumer, consumer.SpanKind())
+	assert.Equal(t, producer.SpanContext().TraceID(), consumer.SpanContext().TraceID())
+	assert.Equal(t, producer.SpanContext().SpanID(), consumer.Parent().SpanID())
+	assert.Equal(t, consumer.SpanContext().SpanID(), handlerSpan.SpanID(), "the handler runs inside the consumer span")
+	assert.Equal(t, map[string]interface{}{
+		"messaging.destination.subscription.name": "orders-subscription",
+		"messaging.destination.name":              "orders",
+		"messaging.operation":                     "process",
+		"event_name":                              "order.created",
+		"messaging.message.conversation_id":       "correlation",
+		"acknowledged":                            true,
+	}, attributes(consumer))
+}
+
+func TestPublishError(t *testing.T) {
+	recorder, opts := newRecorder()
+	publisher := queuesgo.WrapPublisher(&capturingPublisher{err: errors.New("unavailable")}, PublisherMiddleware("orders", opts...))
+
+	_, err := publisher.PublishSync(context.Background(), &queuesgo.Event{})
+
+	assert.EqualError(t, err, "unavailable")
+	require.Len(t, recorder.Ended(), 1)
+	assert.Equal(t, codes.Error, recorder.Ended()[0].Status().Code)
+	assert.Equal(t, "unavailable", recorder.Ended()[0].Status().Description)
+}
+
+func TestReceiveWithoutTraceContext(t *testing.T) {
+	recorder, opts := newRecorder()
+	handler := Middleware("orders-subscription", opts...)(func(ctx context.Context, event queuesgo.Event) (bool, error) {
+		return false, errors.New("unavailable")
+	})
+
+	ack, err := handler(context.Background(), queuesgo.Event{})
+
+	assert.False(t, ack)
+	assert.EqualError(t, err, "unavailable")
+	require.Len(t, recorder.Ended(), 1)
+	consumer := recorder.Ended()[0]
+	assert.False(t, consumer.Parent().IsValid(), "the consumer span is a root span")
+	assert.Equal(t, codes.Error, consumer.Status().Code)
+	assert.Equal(t, false, attributes(consumer)["acknowledged"])
+}
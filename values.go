@@ -1,6 +1,10 @@
 package queuesgo
 
-import "reflect"
+import (
+	"reflect"
+	"strings"
+	"time"
+)
 
 func ValidateType(objType interface{}) bool {
 	t := reflect.TypeOf(objType)
@@ -19,41 +23,191 @@ func GetName(val interface{}) string {
 	}
 }
 
+var timeType = reflect.TypeOf(time.Time{})
+
 /*
 Returns a map string key, val interface with
 key: the name of the field, if it have a json tag it will take the tag name
-value: the type of the field if is primitive (string, int, long. bool...) as string
+the fields are the ones written by encoding/json: the unexported fields and the ones with the json:"-" tag are skipped,
+the fields with the omitempty option are nullable and the numbers and bools with the string option are strings
+value: the type of the field if is primitive (string, int, long. bool...) as string, using the underlying kind for named types
+time.Time fields are "timestamp", or "date" with the avro:"date" tag, and byte slices are "bytes"
 if is a complex type (structure, map, slice) the value will have an slice with 2 positions
-the first position indicates the type, array for slices, map or the name of the structure
+the first position indicates the type, array for slices, map or the name of the structure, the field name for anonymous ones
 the second position indicates the type of the slice or map, a map with the previous rules for embedded structures
 with maps you can assume a key string as it is the most usual, but for maps there is an extra position with the key type
+pointers are nullable, with the type of the pointed value on the second position
+string fields with the avro:"enum=A,B" tag are enum, with the name of the type and the slice of symbols
+The fields of the anonymous embedded structures without json tag are flattened, as encoding/json does,
+the fields of the outer structure take precedence
+A structure found again inside its own fields, directly or through other structures, is the name of the structure,
+so the recursive types reference the record being defined
 */
 func GetFields(val interface{}) map[string]interface{} {
-	v := reflect.Indirect(reflect.ValueOf(val))
-	fields := make(map[string]interface{}, v.NumField())
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Type().Field(i)
+	return getFields(reflect.Indirect(reflect.ValueOf(val)).Type(), map[reflect.Type]bool{})
+}
+
+// getFields returns the fields of the structure, visiting has the structures being expanded around it
+func getFields(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	visiting[t] = true
+	defer delete(visiting, t)
+	fields := make(map[string]interface{}, t.NumField())
+	var embedded []map[string]interface{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
 		jsonTag := field.Tag.Get("json")
-		if jsonTag != "" {
-			fields[jsonTag] = getType(v.Field(i).Type())
-		} else {
-			fields[field.Name] = getType(v.Field(i).Type())
+		// The tag "-," names the field "-" instead of skipping it
+		if jsonTag == "-" {
+			continue
+		}
+		name, options := parseJSONTag(jsonTag)
+		if field.Anonymous && name == "" && isEmbeddedStruct(field.Type) {
+			embeddedType := field.Type
+			if embeddedType.Kind() == reflect.Ptr {
+				embeddedType = embeddedType.Elem()
+			}
+			// A structure embedding a pointer to itself has no more fields to promote
+			if !visiting[embeddedType] {
+				embedded = append(embedded, getEmbeddedFields(field.Type, visiting))
+			}
+			continue
+		}
+		// encoding/json ignores the unexported fields
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fieldType := getFieldType(field, visiting)
+		if hasJSONOption(options, "string") {
+			fieldType = quotedType(fieldType)
+		}
+		// The empty values are omitted, so the field can be missing
+		if hasJSONOption(options, "omitempty") && !isNullable(fieldType) {
+			fieldType = []interface{}{"nullable", fieldType}
+		}
+		fields[name] = fieldType
+	}
+	for _, promoted := range embedded {
+		for name, fieldType := range promoted {
+			if _, ok := fields[name]; !ok {
+				fields[name] = fieldType
+			}
 		}
 	}
 	return fields
 }
 
-func getType(t reflect.Type) interface{} {
+// parseJSONTag splits the json tag into the name and the comma separated options
+func parseJSONTag(tag string) (string, string) {
+	if i := strings.Index(tag, ","); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+func hasJSONOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// quotedType returns the type of a field with the string option, which encoding/json writes as a string if it is a number or a bool
+func quotedType(fieldType interface{}) interface{} {
+	if isNullable(fieldType) {
+		return []interface{}{"nullable", quotedType(fieldType.([]interface{})[1])}
+	}
+	switch fieldType {
+	case "bool", "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64":
+		return "string"
+	default:
+		return fieldType
+	}
+}
+
+func isEmbeddedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+// The fields of an embedded pointer are missing when it is nil, so they are nullable
+func getEmbeddedFields(t reflect.Type, visiting map[reflect.Type]bool) map[string]interface{} {
+	if t.Kind() != reflect.Ptr {
+		return getFields(t, visiting)
+	}
+	fields := getFields(t.Elem(), visiting)
+	for name, fieldType := range fields {
+		if !isNullable(fieldType) {
+			fields[name] = []interface{}{"nullable", fieldType}
+		}
+	}
+	return fields
+}
+
+func isNullable(fieldType interface{}) bool {
+	complexType, ok := fieldType.([]interface{})
+	return ok && complexType[0] == "nullable"
+}
+
+// getFieldType returns the type of the field applying the avro tag options
+func getFieldType(field reflect.StructField, visiting map[reflect.Type]bool) interface{} {
+	t := field.Type
+	pointer := t.Kind() == reflect.Ptr
+	if pointer {
+		t = t.Elem()
+	}
+	avroTag := field.Tag.Get("avro")
+	var fieldType interface{}
+	switch {
+	case strings.HasPrefix(avroTag, "enum=") && t.Kind() == reflect.String:
+		name := t.Name()
+		if name == "" || name == "string" {
+			name = field.Name
+		}
+		fieldType = []interface{}{"enum", name, strings.Split(strings.TrimPrefix(avroTag, "enum="), ",")}
+	case avroTag == "date" && t == timeType:
+		fieldType = "date"
+	default:
+		fieldType = getType(t, field.Name, visiting)
+	}
+	if pointer {
+		return []interface{}{"nullable", fieldType}
+	}
+	return fieldType
+}
+
+// getType returns the type of t, the anonymous structures are named after the field holding them
+func getType(t reflect.Type, fieldName string, visiting map[reflect.Type]bool) interface{} {
 	switch t.Kind() {
+	case reflect.Ptr:
+		return []interface{}{"nullable", getType(t.Elem(), fieldName, visiting)}
 	case reflect.Struct:
-		val := reflect.New(t).Interface()
-		return []interface{}{GetName(val), GetFields(val)}
+		if t == timeType {
+			return "timestamp"
+		}
+		name := t.Name()
+		if name == "" {
+			name = fieldName
+		}
+		if visiting[t] {
+			return name
+		}
+		return []interface{}{name, getFields(t, visiting)}
 	case reflect.Slice:
-		return []interface{}{"array", getType(t.Elem())}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "bytes"
+		}
+		return []interface{}{"array", getType(t.Elem(), fieldName, visiting)}
 	case reflect.Map:
-		return []interface{}{"map", getType(t.Elem()), getType(t.Key())}
+		return []interface{}{"map", getType(t.Elem(), fieldName, visiting), getType(t.Key(), fieldName, visiting)}
 	default:
-		return t.Name()
+		return t.Kind().String()
 	}
 }
 
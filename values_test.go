@@ -0,0 +1,157 @@
+package queuesgo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type node struct {
+	Name string `json:"name"`
+	Next *node  `json:"next"`
+}
+
+type tree struct {
+	Value    int    `json:"value"`
+	Children []tree `json:"children"`
+}
+
+type department struct {
+	Name      string     `json:"name"`
+	Employees []employee `json:"employees"`
+}
+
+type employee struct {
+	Name       string      `json:"name"`
+	Department *department `json:"department"`
+}
+
+type chain struct {
+	*chain
+	ID string `json:"id"`
+}
+
+func TestGetFieldsSelfReferencingPointer(t *testing.T) {
+	fields := GetFields(node{})
+
+	assert.Equal(t, map[string]interface{}{
+		"name": "string",
+		"next": []interface{}{"nullable", "node"},
+	}, fields)
+}
+
+func TestGetFieldsSelfReferencingSlice(t *testing.T) {
+	fields := GetFields(&tree{})
+
+	assert.Equal(t, map[string]interface{}{
+		"value":    "int",
+		"children": []interface{}{"array", "tree"},
+	}, fields)
+}
+
+func TestGetFieldsMutuallyRecursive(t *testing.T) {
+	fields := GetFields(department{})
+
+	assert.Equal(t, map[string]interface{}{
+		"name": "string",
+		"employees": []interface{}{"array", []interface{}{"employee", map[string]interface{}{
+			"name":       "string",
+			"department": []interface{}{"nullable", "department"},
+		}}},
+	}, fields)
+}
+
+func TestGetFieldsRepeatedTypeIsExpandedOutsideItsOwnFields(t *testing.T) {
+	type pair struct {
+		First  node `json:"first"`
+		Second node `json:"second"`
+	}
+	expanded := []interface{}{"node", map[string]interface{}{
+		"name": "string",
+		"next": []interface{}{"nullable", "node"},
+	}}
+
+	fields := GetFields(pair{})
+
+	assert.Equal(t, map[string]interface{}{"first": expanded, "second": expanded}, fields)
+}
+
+func TestGetFieldsEmbeddedSelfPointer(t *testing.T) {
+	fields := GetFields(chain{})
+
+	assert.Equal(t, map[string]interface{}{"id": "string"}, fields)
+}
+
+type tagged struct {
+	Name     string  `json:"name"`
+	Nickname string  `json:"nickname,omitempty"`
+	Skipped  string  `json:"-"`
+	Dash     string  `json:"-,"`
+	Count    int     `json:"count,string"`
+	Maybe    *bool   `json:"maybe,string"`
+	Untagged float64 `json:",omitempty"`
+	private  string
+}
+
+type base struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type extended struct {
+	base
+	*tagged
+	Name  string `json:"name"`
+	Named base   `json:"named"`
+}
+
+func TestGetFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:  "json tags",
+			value: tagged{},
+			expected: map[string]interface{}{
+				"name":     "string",
+				"nickname": []interface{}{"nullable", "string"},
+				"-":        "string",
+				"count":    "string",
+				"maybe":    []interface{}{"nullable", "string"},
+				"Untagged": []interface{}{"nullable", "float64"},
+			},
+		},
+		{
+			name:  "embedded structs",
+			value: &extended{},
+			expected: map[string]interface{}{
+				"id":       "string",
+				"name":     "string",
+				"nickname": []interface{}{"nullable", "string"},
+				"-":        []interface{}{"nullable", "string"},
+				"count":    []interface{}{"nullable", "string"},
+				"maybe":    []interface{}{"nullable", "string"},
+				"Untagged": []interface{}{"nullable", "float64"},
+				"named":    []interface{}{"base", map[string]interface{}{"id": "string", "name": "string"}},
+			},
+		},
+		{
+			name: "anonymous structures",
+			value: struct {
+				Owner struct {
+					Name string `json:"name"`
+				} `json:"owner"`
+			}{},
+			expected: map[string]interface{}{
+				"owner": []interface{}{"Owner", map[string]interface{}{"name": "string"}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, GetFields(test.value))
+		})
+	}
+}